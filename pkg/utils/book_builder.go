@@ -0,0 +1,502 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+// BookData is a book read by the import functions, e.g. ImportUsfm.
+type BookData struct {
+	Book        *biblev1.Book
+	Chapters    []*biblev1.Chapter
+	Verses      []*biblev1.Verse
+	Marks       []*biblev1.Mark
+	Headings    []*biblev1.Heading
+	Psalms      []*biblev1.PsalmMetadata
+	Diagnostics []ImportDiagnostic
+}
+
+// ImportDiagnostic reports content that could not be imported as-is, e.g. an
+// unsupported USFM marker. Marker is the USFM marker or XML element being read
+// when the problem was found.
+type ImportDiagnostic struct {
+	Line    int
+	Marker  string
+	Message string
+}
+
+var bookMarkIdPrefixes = map[biblev1.MarkKind]string{
+	biblev1.MarkKind_MARK_KIND_FOOTNOTE:       "fn",
+	biblev1.MarkKind_MARK_KIND_REFERENCE:      "ref",
+	biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: "woj",
+}
+
+// NOTE: Text with whitespace collapsed, the trailing space is dropped
+type textBuffer struct {
+	sb        strings.Builder
+	length    int
+	lastSpace bool
+}
+
+func (b *textBuffer) write(text string) {
+	for _, c := range text {
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			if b.length == 0 || b.lastSpace {
+				continue
+			}
+
+			b.sb.WriteRune(' ')
+			b.lastSpace = true
+		default:
+			b.sb.WriteRune(c)
+			b.lastSpace = false
+		}
+
+		b.length++
+	}
+}
+
+func (b *textBuffer) String() string {
+	return strings.TrimSuffix(b.sb.String(), " ")
+}
+
+func (b *textBuffer) Reset() {
+	b.sb.Reset()
+	b.length = 0
+	b.lastSpace = false
+}
+
+type bookBlock int
+
+const (
+	// NOTE: Text is dropped with a diagnostic
+	bookBlockNone bookBlock = iota
+	// NOTE: Text is dropped silently
+	bookBlockIgnored
+	bookBlockHeading
+	bookBlockPsalm
+	bookBlockVerse
+)
+
+type bookNote struct {
+	// NOTE: nil if the note is dropped
+	mark   *biblev1.Mark
+	buffer textBuffer
+}
+
+// NOTE: Builds the verses, headings, marks and psalm titles of a book from the
+// structure found by the import functions. Ids are "CODE.1" for chapters,
+// "CODE.1.2" for verses, "CODE.1.2.1" for sub-verses, "CODE.1.fn1" for marks,
+// "CODE.1.h1" for headings and "CODE.1.d1" for psalm titles.
+type bookBuilder struct {
+	data *BookData
+	code string
+	// NOTE: Position of the content being read, for diagnostics
+	line   int
+	marker string
+	block  bookBlock
+
+	chapter *biblev1.Chapter
+	// NOTE: Last verse of the chapter, sub-verses continue it
+	verse     *biblev1.Verse
+	verseOpen bool
+	heading   *biblev1.Heading
+	buffer    textBuffer
+	marks     []*biblev1.Mark
+	// NOTE: Open words of Jesus, continued in the next verse if not closed
+	openMarks       []*biblev1.Mark
+	note            *bookNote
+	pendingHeadings []*biblev1.Heading
+
+	paragraphNumber int32
+	paragraphIndex  int32
+	isPoetry        bool
+	counters        map[biblev1.MarkKind]int32
+	headingCount    int32
+	psalmCount      int32
+}
+
+func newBookBuilder() *bookBuilder {
+	return &bookBuilder{
+		data: &BookData{
+			Chapters:    make([]*biblev1.Chapter, 0),
+			Verses:      make([]*biblev1.Verse, 0),
+			Marks:       make([]*biblev1.Mark, 0),
+			Headings:    make([]*biblev1.Heading, 0),
+			Psalms:      make([]*biblev1.PsalmMetadata, 0),
+			Diagnostics: make([]ImportDiagnostic, 0),
+		},
+	}
+}
+
+func (b *bookBuilder) diagnose(message string) {
+	b.data.Diagnostics = append(b.data.Diagnostics, ImportDiagnostic{
+		Line:    b.line,
+		Marker:  b.marker,
+		Message: message,
+	})
+}
+
+// NOTE: Finishes the book, its name may be empty
+func (b *bookBuilder) finish(name string) *BookData {
+	b.finishBlock()
+	b.finishChapter()
+
+	b.data.Book = &biblev1.Book{
+		Id:   b.code,
+		Code: b.code,
+		Name: name,
+	}
+
+	return b.data
+}
+
+func (b *bookBuilder) write(text string) {
+	if b.note != nil {
+		b.note.buffer.write(text)
+
+		return
+	}
+
+	switch b.block {
+	case bookBlockNone:
+		if strings.TrimSpace(text) != "" {
+			b.diagnose("text outside of a verse is dropped")
+		}
+	case bookBlockHeading, bookBlockPsalm:
+		b.buffer.write(text)
+	case bookBlockVerse:
+		if !b.verseOpen {
+			if strings.TrimSpace(text) == "" {
+				return
+			}
+
+			if b.verse == nil {
+				b.diagnose("text outside of a verse is dropped")
+
+				return
+			}
+
+			b.startSubVerse()
+		}
+
+		b.buffer.write(text)
+	}
+}
+
+// NOTE: Drops the text until the next block
+func (b *bookBuilder) ignore() {
+	b.finishBlock()
+	b.block = bookBlockIgnored
+}
+
+func (b *bookBuilder) startChapter(number int) {
+	b.finishBlock()
+	b.finishChapter()
+
+	if b.code == "" {
+		b.diagnose("chapter before the book id")
+	}
+
+	b.chapter = &biblev1.Chapter{
+		Id:     fmt.Sprintf("%s.%d", b.code, number),
+		Number: int32(number),
+		BookId: b.code,
+	}
+
+	b.data.Chapters = append(b.data.Chapters, b.chapter)
+
+	b.verse = nil
+	b.paragraphNumber = -1
+	b.paragraphIndex = 0
+	b.isPoetry = false
+	b.counters = make(map[biblev1.MarkKind]int32)
+	b.headingCount = 0
+	b.psalmCount = 0
+}
+
+// NOTE: Closes the chapter, headings after the last verse are kept without
+// VerseId
+func (b *bookBuilder) finishChapter() {
+	for _, heading := range b.pendingHeadings {
+		b.diagnose(fmt.Sprintf("heading %q is not followed by a verse", heading.Text))
+		b.data.Headings = append(b.data.Headings, heading)
+	}
+
+	b.pendingHeadings = nil
+
+	if len(b.openMarks) > 0 {
+		b.diagnose("unclosed words of Jesus at the end of the chapter")
+	}
+
+	b.openMarks = nil
+}
+
+// NOTE: Starts a new paragraph, the verses after it are poetry if isPoetry
+func (b *bookBuilder) startParagraph(isPoetry bool) {
+	b.finishBlock()
+	b.paragraphNumber++
+	b.paragraphIndex = 0
+	b.isPoetry = isPoetry
+	b.block = bookBlockVerse
+}
+
+// NOTE: Starts a new line of the current paragraph, e.g. a line of poetry
+func (b *bookBuilder) continueParagraph(isPoetry bool) {
+	b.finishBlock()
+	b.isPoetry = isPoetry
+	b.block = bookBlockVerse
+}
+
+func (b *bookBuilder) startVerse(label string, number int) {
+	b.finishBlock()
+	b.block = bookBlockVerse
+
+	if b.chapter == nil {
+		b.diagnose("verse outside of a chapter is dropped")
+		b.block = bookBlockNone
+
+		return
+	}
+
+	b.openVerse(&biblev1.Verse{
+		Id:        fmt.Sprintf("%s.%s", b.chapter.Id, label),
+		Label:     label,
+		Number:    int32(number),
+		ChapterId: b.chapter.Id,
+	})
+}
+
+// NOTE: Parses the verse number at the start of a label, e.g. 1 for "1a"
+func verseLabelNumber(label string) (int, error) {
+	numberEnd := strings.IndexFunc(label, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	if numberEnd == -1 {
+		numberEnd = len(label)
+	}
+
+	return strconv.Atoi(label[:numberEnd])
+}
+
+// NOTE: Ends the verse, the text until the next verse is dropped
+func (b *bookBuilder) endVerse() {
+	b.finishBlock()
+	b.verse = nil
+}
+
+func (b *bookBuilder) startSubVerse() {
+	b.openVerse(&biblev1.Verse{
+		Id:            fmt.Sprintf("%s.%s.%d", b.chapter.Id, b.verse.Label, b.verse.SubVerseIndex+1),
+		Label:         b.verse.Label,
+		Number:        b.verse.Number,
+		SubVerseIndex: b.verse.SubVerseIndex + 1,
+		ChapterId:     b.chapter.Id,
+	})
+}
+
+func (b *bookBuilder) openVerse(verse *biblev1.Verse) {
+	b.paragraphNumber = max(b.paragraphNumber, 0)
+
+	verse.ParagraphNumber = b.paragraphNumber
+	verse.ParagraphIndex = b.paragraphIndex
+	verse.IsPoetry = b.isPoetry
+
+	b.paragraphIndex++
+
+	b.verse = verse
+	b.verseOpen = true
+	b.buffer.Reset()
+
+	for _, heading := range b.pendingHeadings {
+		heading.VerseId = verse.Id
+		b.data.Headings = append(b.data.Headings, heading)
+	}
+
+	b.pendingHeadings = nil
+
+	// NOTE: Words of Jesus spanning several verses continue at the start of
+	// this one, with the same Id
+	for _, mark := range b.openMarks {
+		mark.StartOffset = 0
+		mark.TargetId = verse.Id
+	}
+}
+
+func (b *bookBuilder) startHeading(level int) {
+	if b.chapter == nil {
+		b.diagnose("heading outside of a chapter is dropped")
+		b.ignore()
+
+		return
+	}
+
+	b.finishBlock()
+
+	b.heading = &biblev1.Heading{
+		Id:        fmt.Sprintf("%s.h%d", b.chapter.Id, b.headingCount+1),
+		Level:     int32(level),
+		SortOrder: b.headingCount,
+		ChapterId: b.chapter.Id,
+	}
+	b.headingCount++
+
+	b.block = bookBlockHeading
+}
+
+func (b *bookBuilder) startPsalm() {
+	if b.chapter == nil {
+		b.diagnose("psalm title outside of a chapter is dropped")
+		b.ignore()
+
+		return
+	}
+
+	b.finishBlock()
+	b.block = bookBlockPsalm
+}
+
+func (b *bookBuilder) newMark(kind biblev1.MarkKind, targetType biblev1.MarkTargetType, targetId string) *biblev1.Mark {
+	sortOrder := b.counters[kind]
+	b.counters[kind]++
+
+	return &biblev1.Mark{
+		Id:          fmt.Sprintf("%s.%s%d", b.chapter.Id, bookMarkIdPrefixes[kind], sortOrder+1),
+		Kind:        kind,
+		SortOrder:   sortOrder,
+		StartOffset: int32(b.buffer.length),
+		EndOffset:   int32(b.buffer.length),
+		TargetId:    targetId,
+		TargetType:  targetType,
+		ChapterId:   b.chapter.Id,
+	}
+}
+
+// NOTE: Opens a footnote or reference at the current position, its text is
+// written until closeNote
+func (b *bookBuilder) openNote(kind biblev1.MarkKind) {
+	b.note = &bookNote{}
+
+	switch {
+	case b.block == bookBlockVerse && b.verse != nil:
+		if !b.verseOpen {
+			b.startSubVerse()
+		}
+
+		b.note.mark = b.newMark(kind, biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, b.verse.Id)
+	case b.block == bookBlockHeading:
+		b.note.mark = b.newMark(kind, biblev1.MarkTargetType_MARK_TARGET_TYPE_HEADING, b.heading.Id)
+	default:
+		b.diagnose("note outside of a verse or heading is dropped")
+	}
+}
+
+func (b *bookBuilder) closeNote() {
+	if b.note == nil {
+		return
+	}
+
+	if b.note.mark != nil {
+		b.note.mark.Content = b.note.buffer.String()
+		b.marks = append(b.marks, b.note.mark)
+	}
+
+	b.note = nil
+}
+
+func (b *bookBuilder) openWordsOfJesus() {
+	if b.block != bookBlockVerse || b.verse == nil {
+		b.diagnose("words of Jesus outside of a verse are kept as text")
+
+		return
+	}
+
+	if !b.verseOpen {
+		b.startSubVerse()
+	}
+
+	b.openMarks = append(b.openMarks, b.newMark(biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, b.verse.Id))
+}
+
+func (b *bookBuilder) closeWordsOfJesus() {
+	if len(b.openMarks) == 0 {
+		if b.block == bookBlockVerse {
+			b.diagnose("words of Jesus closed without being opened")
+		}
+
+		return
+	}
+
+	mark := b.openMarks[len(b.openMarks)-1]
+	b.openMarks = b.openMarks[:len(b.openMarks)-1]
+
+	mark.EndOffset = int32(b.buffer.length)
+	b.marks = append(b.marks, mark)
+}
+
+// NOTE: Stores the text of the current heading, psalm title or verse
+func (b *bookBuilder) finishBlock() {
+	b.closeNote()
+
+	switch b.block {
+	case bookBlockHeading:
+		b.heading.Text = b.buffer.String()
+		b.pendingHeadings = append(b.pendingHeadings, b.heading)
+		b.data.Marks = append(b.data.Marks, clampMarks(b.marks, b.heading.Text)...)
+		b.heading = nil
+	case bookBlockPsalm:
+		b.data.Psalms = append(b.data.Psalms, &biblev1.PsalmMetadata{
+			Id:        fmt.Sprintf("%s.d%d", b.chapter.Id, b.psalmCount+1),
+			Text:      b.buffer.String(),
+			SortOrder: b.psalmCount,
+			ChapterId: b.chapter.Id,
+		})
+		b.psalmCount++
+	case bookBlockVerse:
+		if !b.verseOpen {
+			break
+		}
+
+		b.verse.Text = b.buffer.String()
+
+		// NOTE: Words of Jesus still open are split at the end of the verse
+		for _, mark := range b.openMarks {
+			fragment := cloneMark(mark)
+			fragment.EndOffset = int32(b.buffer.length)
+			b.marks = append(b.marks, fragment)
+		}
+
+		b.data.Verses = append(b.data.Verses, b.verse)
+		b.data.Marks = append(b.data.Marks, clampMarks(b.marks, b.verse.Text)...)
+		b.verseOpen = false
+	}
+
+	b.marks = nil
+	b.buffer.Reset()
+	b.block = bookBlockNone
+}
+
+// NOTE: Clamps the offsets of marks to the text, which may have lost its
+// trailing space, and sets the Content of words of Jesus to the covered text
+func clampMarks(marks []*biblev1.Mark, text string) []*biblev1.Mark {
+	runes := []rune(text)
+	length := len(runes)
+
+	for _, mark := range marks {
+		mark.StartOffset = int32(clampOffset(int(mark.StartOffset), length))
+		mark.EndOffset = int32(clampOffset(int(mark.EndOffset), length))
+
+		if mark.Kind == biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS {
+			mark.Content = string(runes[mark.StartOffset:mark.EndOffset])
+		}
+	}
+
+	slices.SortStableFunc(marks, compareMarkPosition)
+
+	return marks
+}
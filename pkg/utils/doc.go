@@ -0,0 +1,7 @@
+// Package utils renders verses with their marks, headings and psalm titles to
+// Markdown, HTML, plain text and LaTeX, and converts them from and to Bible
+// formats such as USFM, USX, OSIS and Zefania.
+//
+// The OSIS and Zefania readers and writers handle one book at a time, so a
+// whole Bible does not have to be held in memory.
+package utils
@@ -0,0 +1,410 @@
+package utils
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/samber/lo"
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+type DocumentNodeType string
+
+const (
+	DocumentNodeChapter    DocumentNodeType = "chapter"
+	DocumentNodeHeading    DocumentNodeType = "heading"
+	DocumentNodePsalmTitle DocumentNodeType = "psalmTitle"
+	DocumentNodeParagraph  DocumentNodeType = "paragraph"
+	DocumentNodePoetryLine DocumentNodeType = "poetryLine"
+	DocumentNodeVerse      DocumentNodeType = "verse"
+	DocumentNodeText       DocumentNodeType = "text"
+	// NOTE: Text covered by a mark, e.g. words of Jesus
+	DocumentNodeSpan DocumentNodeType = "span"
+	// NOTE: Position of a footnote or reference, NoteId is the id of its
+	// DocumentNote. The children of a note covering text are that text, which
+	// the built-in renderers replace with the reference
+	DocumentNodeNoteRef DocumentNodeType = "noteRef"
+)
+
+// Document is the tree of a passage built by BuildDocument, before it is
+// rendered to a format. It is serialisable to JSON, so clients can render
+// passages natively, and RenderDocument only reads its exported fields, so a
+// Document read back from JSON renders the same:
+//
+//	chapter → heading | psalmTitle | paragraph | poetryLine
+//	paragraph | poetryLine → verse
+//	heading | psalmTitle | verse → text | span | noteRef
+//	span | noteRef → text | span | noteRef
+type Document struct {
+	Chapters []*DocumentNode `json:"chapters"`
+	// NOTE: Footnotes and references of the passage, in the (Kind, SortOrder)
+	// order of the marks
+	Notes []*DocumentNote `json:"notes"`
+}
+
+// DocumentNode is a node of a Document, the fields used depend on its Type.
+type DocumentNode struct {
+	Type DocumentNodeType `json:"type"`
+	// NOTE: Id of the chapter, heading, psalm title or verse
+	Id string `json:"id,omitempty"`
+	// NOTE: Label of a verse, e.g. "1b", or of a note reference
+	Label  string `json:"label,omitempty"`
+	Number int32  `json:"number,omitempty"`
+	// NOTE: SubVerseIndex and ParagraphNumber of a verse
+	SubVerseIndex int32 `json:"subVerseIndex,omitempty"`
+	Paragraph     int32 `json:"paragraph,omitempty"`
+	// NOTE: Level of a heading, starting from 1
+	Level int32 `json:"level,omitempty"`
+	// NOTE: Markdown text of a text node, as stored
+	Text string `json:"text,omitempty"`
+	// NOTE: Mark kind of a span or note reference, e.g. "wordsOfJesus"
+	Kind     string          `json:"kind,omitempty"`
+	NoteId   string          `json:"noteId,omitempty"`
+	Children []*DocumentNode `json:"children,omitempty"`
+}
+
+// DocumentNote is a footnote or reference of a Document.
+type DocumentNote struct {
+	// NOTE: Id of the mark
+	Id   string `json:"id"`
+	Kind string `json:"kind"`
+	// NOTE: Label of the FootnoteNumbering scheme
+	Label string `json:"label"`
+	// NOTE: Label given to the label functions, the stored Mark.Label with
+	// FootnoteNumberingSortOrder
+	MarkLabel string `json:"markLabel,omitempty"`
+	// NOTE: SortOrder + 1, the number of the default labels
+	Number    int32  `json:"number"`
+	ChapterId string `json:"chapterId"`
+	TargetId  string `json:"targetId"`
+	// NOTE: Markdown content, formatted by the FootnoteFormatters
+	Content string `json:"content"`
+}
+
+// NOTE: Names of the mark kinds in a Document
+var documentMarkKinds = map[biblev1.MarkKind]string{
+	biblev1.MarkKind_MARK_KIND_FOOTNOTE:       "footnote",
+	biblev1.MarkKind_MARK_KIND_REFERENCE:      "reference",
+	biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: "wordsOfJesus",
+}
+
+func documentMarkKind(kind biblev1.MarkKind) string {
+	if name, ok := documentMarkKinds[kind]; ok {
+		return name
+	}
+
+	return "unspecified"
+}
+
+// NOTE: Mark kind of a name of documentMarkKind
+func parseDocumentMarkKind(name string) biblev1.MarkKind {
+	for kind, kindName := range documentMarkKinds {
+		if kindName == name {
+			return kind
+		}
+	}
+
+	return biblev1.MarkKind_MARK_KIND_UNSPECIFIED
+}
+
+// NOTE: Splits text into text, span and note reference nodes, with the same
+// nesting as InjectMarkLabel
+func documentInlines(text string, marks []*biblev1.Mark, numbering FootnoteNumbering) []*DocumentNode {
+	resolvedMarks := ResolveMarks(snapMarksToGraphemes(text, marks), nil)
+
+	slices.SortFunc(resolvedMarks, compareMarkNesting)
+
+	runes := []rune(text)
+
+	return documentInlineRange(runes, 0, len(runes), resolvedMarks, numbering)
+}
+
+func documentInlineRange(runes []rune, from, to int, marks []*biblev1.Mark, numbering FootnoteNumbering) []*DocumentNode {
+	nodes := make([]*DocumentNode, 0)
+
+	pos := from
+
+	addText := func(end int) {
+		if end > pos {
+			nodes = append(nodes, &DocumentNode{Type: DocumentNodeText, Text: string(runes[pos:end])})
+		}
+	}
+
+	for i := 0; i < len(marks); {
+		mark := marks[i]
+
+		startOffset := max(int(mark.StartOffset), pos)
+		endOffset := min(max(int(mark.EndOffset), startOffset), to)
+
+		addText(startOffset)
+
+		// NOTE: Collect marks enclosed by the current span mark
+		j := i + 1
+
+		for j < len(marks) && int(marks[j].StartOffset) < endOffset && startOffset < endOffset {
+			j++
+		}
+
+		children := documentInlineRange(runes, startOffset, endOffset, marks[i+1:j], numbering)
+
+		switch mark.Kind {
+		case biblev1.MarkKind_MARK_KIND_FOOTNOTE, biblev1.MarkKind_MARK_KIND_REFERENCE:
+			nodes = append(nodes, &DocumentNode{Type: DocumentNodeNoteRef, Label: numberedMark(mark, numbering).Label, Kind: documentMarkKind(mark.Kind), NoteId: mark.Id, Children: children})
+		case biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS:
+			nodes = append(nodes, &DocumentNode{Type: DocumentNodeSpan, Kind: documentMarkKind(mark.Kind), Children: children})
+		default:
+			nodes = append(nodes, children...)
+		}
+
+		pos = endOffset
+		i = j
+	}
+
+	addText(to)
+
+	return nodes
+}
+
+// NOTE: Footnotes and references split across verses by SplitPassageMark
+// (same Id and Kind) are reduced to one reference at the end of their passage,
+// so each note is referenced and listed once
+func mergeSplitNotes(marks []*biblev1.Mark, verses []*biblev1.Verse) []*biblev1.Mark {
+	verseIndexes := make(map[string]int, len(verses))
+
+	for i, verse := range verses {
+		verseIndexes[verse.Id] = i
+	}
+
+	type fragmentKey struct {
+		id   string
+		kind biblev1.MarkKind
+	}
+
+	// NOTE: First and last verse of the fragments, -1 for marks not targeting
+	// one of verses
+	type fragmentRange struct {
+		first, last int
+	}
+
+	verseIndex := func(mark *biblev1.Mark) int {
+		if idx, ok := verseIndexes[mark.TargetId]; ok && mark.TargetType == biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE {
+			return idx
+		}
+
+		return -1
+	}
+
+	isNote := func(mark *biblev1.Mark) bool {
+		return mark.Id != "" && (mark.Kind == biblev1.MarkKind_MARK_KIND_FOOTNOTE || mark.Kind == biblev1.MarkKind_MARK_KIND_REFERENCE)
+	}
+
+	ranges := make(map[fragmentKey]fragmentRange)
+
+	for _, mark := range marks {
+		if !isNote(mark) {
+			continue
+		}
+
+		key := fragmentKey{id: mark.Id, kind: mark.Kind}
+		idx := verseIndex(mark)
+
+		if r, ok := ranges[key]; ok {
+			ranges[key] = fragmentRange{first: min(r.first, idx), last: max(r.last, idx)}
+		} else {
+			ranges[key] = fragmentRange{first: idx, last: idx}
+		}
+	}
+
+	newMarks := make([]*biblev1.Mark, 0, len(marks))
+
+	for _, mark := range marks {
+		r := ranges[fragmentKey{id: mark.Id, kind: mark.Kind}]
+
+		switch {
+		case !isNote(mark) || r.first == r.last:
+			newMarks = append(newMarks, mark)
+		case verseIndex(mark) == r.last:
+			newMark := cloneMark(mark)
+			newMark.StartOffset = newMark.EndOffset
+
+			newMarks = append(newMarks, newMark)
+		}
+	}
+
+	return newMarks
+}
+
+// BuildDocument builds the tree of a passage. Headings, psalm titles and mark
+// kinds hidden by options are left out, the other options are used by
+// RenderDocument.
+//
+// Footnotes and references split across verses by SplitPassageMark are
+// referenced once, at the end of their passage, and listed once.
+func BuildDocument(verses []*biblev1.Verse, marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata, options *RenderOptions) (*Document, error) {
+	return buildDocument(verses, marks, headings, psalms, options, make(map[footnoteCounterKey]int))
+}
+
+// NOTE: counters holds the footnote numbering state, shared by the chapters
+// of StreamVerses
+func buildDocument(verses []*biblev1.Verse, marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata, options *RenderOptions, counters map[footnoteCounterKey]int) (*Document, error) {
+	if options == nil {
+		options = &RenderOptions{}
+	}
+
+	marks = mergeSplitNotes(marks, verses)
+
+	showHeadings := boolOption(options.ShowHeadings, true)
+	showPsalmTitles := boolOption(options.ShowPsalmTitles, true)
+
+	// NOTE: Hidden mark kinds are neither injected nor listed in the footnote
+	// section
+	verseMarkKinds := make([]biblev1.MarkKind, 0)
+	headingMarkKinds := make([]biblev1.MarkKind, 0)
+
+	if boolOption(options.ShowFootnotes, true) {
+		verseMarkKinds = append(verseMarkKinds, biblev1.MarkKind_MARK_KIND_FOOTNOTE)
+		headingMarkKinds = append(headingMarkKinds, biblev1.MarkKind_MARK_KIND_FOOTNOTE)
+	}
+
+	if boolOption(options.ShowReferences, true) {
+		verseMarkKinds = append(verseMarkKinds, biblev1.MarkKind_MARK_KIND_REFERENCE)
+		headingMarkKinds = append(headingMarkKinds, biblev1.MarkKind_MARK_KIND_REFERENCE)
+	}
+
+	if boolOption(options.ShowWordsOfJesus, true) {
+		verseMarkKinds = append(verseMarkKinds, biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS)
+	}
+
+	// NOTE: Footnotes and references listed in the notes, labelled by the
+	// numbering scheme
+	noteMarks := lo.Filter(marks, func(mark *biblev1.Mark, _ int) bool {
+		return slices.Contains(verseMarkKinds, mark.Kind) && mark.Kind != biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS
+	})
+	chapterIds := lo.Uniq(lo.Map(verses, func(verse *biblev1.Verse, _ int) string {
+		return verse.ChapterId
+	}))
+	labels := footnoteLabels(noteMarks, chapterIds, options.FootnoteNumbering, counters)
+
+	// NOTE: Marks keep their stored Label with the default numbering
+	if options.FootnoteNumbering == FootnoteNumberingSortOrder {
+		labels = nil
+	}
+
+	markIndex := NewMarkIndex(marks)
+	headingsByVerse := lo.GroupBy(headings, func(h *biblev1.Heading) string {
+		return h.VerseId
+	})
+	psalmsByChapter := lo.GroupBy(psalms, func(p *biblev1.PsalmMetadata) string {
+		return p.ChapterId
+	})
+
+	document := &Document{
+		Chapters: make([]*DocumentNode, 0),
+		Notes:    make([]*DocumentNote, 0),
+	}
+
+	var chapter, paragraph *DocumentNode
+
+	currPar := 0
+
+	for _, verse := range verses {
+		if chapter == nil || chapter.Id != verse.ChapterId {
+			chapter = &DocumentNode{Type: DocumentNodeChapter, Id: verse.ChapterId}
+			document.Chapters = append(document.Chapters, chapter)
+			paragraph = nil
+		}
+
+		newParagraph := int(verse.ParagraphNumber) > currPar
+		currPar = int(verse.ParagraphNumber)
+
+		if showHeadings {
+			for _, heading := range headingsByVerse[verse.Id] {
+				headingMarks := make([]*biblev1.Mark, 0)
+
+				if len(headingMarkKinds) > 0 {
+					headingMarks = labelMarks(markIndex.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_HEADING, heading.Id, headingMarkKinds...), labels)
+				}
+
+				chapter.Children = append(chapter.Children, &DocumentNode{
+					Type:     DocumentNodeHeading,
+					Id:       heading.Id,
+					Level:    heading.Level,
+					Children: documentInlines(heading.Text, headingMarks, options.FootnoteNumbering),
+				})
+				paragraph = nil
+			}
+		}
+
+		// NOTE: Add the Psalm title to the first verse
+		if showPsalmTitles && verse.SubVerseIndex == 0 && verse.ParagraphNumber == 0 {
+			for _, psalm := range psalmsByChapter[verse.ChapterId] {
+				chapter.Children = append(chapter.Children, &DocumentNode{
+					Type:     DocumentNodePsalmTitle,
+					Id:       psalm.Id,
+					Children: []*DocumentNode{{Type: DocumentNodeText, Text: psalm.Text}},
+				})
+				paragraph = nil
+			}
+		}
+
+		verseMarks := make([]*biblev1.Mark, 0)
+
+		if len(verseMarkKinds) > 0 {
+			verseMarks = labelMarks(markIndex.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, verse.Id, verseMarkKinds...), labels)
+		}
+
+		node := &DocumentNode{
+			Type:          DocumentNodeVerse,
+			Id:            verse.Id,
+			Label:         verse.Label,
+			Number:        verse.Number,
+			SubVerseIndex: verse.SubVerseIndex,
+			Paragraph:     verse.ParagraphNumber,
+			Children:      documentInlines(verse.Text, verseMarks, options.FootnoteNumbering),
+		}
+
+		if verse.IsPoetry {
+			chapter.Children = append(chapter.Children, &DocumentNode{Type: DocumentNodePoetryLine, Children: []*DocumentNode{node}})
+			paragraph = nil
+
+			continue
+		}
+
+		if paragraph == nil || newParagraph {
+			paragraph = &DocumentNode{Type: DocumentNodeParagraph}
+			chapter.Children = append(chapter.Children, paragraph)
+		}
+
+		paragraph.Children = append(paragraph.Children, node)
+	}
+
+	// NOTE: Other schemes list the notes in the order of their labels
+	if options.FootnoteNumbering != FootnoteNumberingSortOrder {
+		noteMarks = footnoteOrder(noteMarks, chapterIds)
+	}
+
+	sortedMarks := slices.SortedStableFunc(slices.Values(labelMarks(noteMarks, labels)), func(a, b *biblev1.Mark) int {
+		if options.FootnoteNumbering != FootnoteNumberingSortOrder {
+			return cmp.Compare(a.Kind, b.Kind)
+		}
+
+		return cmp.Or(cmp.Compare(a.Kind, b.Kind), cmp.Compare(a.SortOrder, b.SortOrder))
+	})
+
+	for _, mark := range sortedMarks {
+		formattedMark := formatFootnote(mark, options.FootnoteFormatters)
+
+		document.Notes = append(document.Notes, &DocumentNote{
+			Id:        mark.Id,
+			Kind:      documentMarkKind(mark.Kind),
+			Label:     numberedMark(mark, options.FootnoteNumbering).Label,
+			MarkLabel: mark.Label,
+			Number:    mark.SortOrder + 1,
+			ChapterId: mark.ChapterId,
+			TargetId:  mark.TargetId,
+			Content:   formattedMark.Content,
+		})
+	}
+
+	return document, nil
+}
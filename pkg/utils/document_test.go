@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func TestBuildDocument(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "PSA.23.1", Number: 1, Label: "1", Text: "The Lord is my shepherd;", IsPoetry: true, ChapterId: "PSA.23"},
+		{Id: "PSA.23.2", Number: 2, Label: "2", Text: "He said, Follow me.", ChapterId: "PSA.23"},
+		{Id: "PSA.23.3", Number: 3, Label: "3", Text: "He restores my soul.", ChapterId: "PSA.23"},
+		{Id: "PSA.24.1", Number: 1, Label: "1", Text: "The earth.", ChapterId: "PSA.24"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Content: "Or *keeper*", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 23, EndOffset: 23, TargetId: "PSA.23.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+		{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 9, EndOffset: 18, TargetId: "PSA.23.2", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+		{Id: "ref1", Content: "Mt 4:19", Kind: biblev1.MarkKind_MARK_KIND_REFERENCE, StartOffset: 15, EndOffset: 15, TargetId: "PSA.23.2", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+	}
+	headings := []*biblev1.Heading{
+		{Id: "h1", Text: "The Good Shepherd", Level: 1, VerseId: "PSA.23.1", ChapterId: "PSA.23"},
+	}
+	psalms := []*biblev1.PsalmMetadata{
+		{Id: "p1", Text: "A Psalm of David.", ChapterId: "PSA.24"},
+	}
+
+	tests := []struct {
+		name     string
+		options  *RenderOptions
+		expected string
+	}{
+		{
+			name: "default",
+			expected: `{"chapters":[` +
+				`{"type":"chapter","id":"PSA.23","children":[` +
+				`{"type":"heading","id":"h1","level":1,"children":[{"type":"text","text":"The Good Shepherd"}]},` +
+				`{"type":"poetryLine","children":[{"type":"verse","id":"PSA.23.1","label":"1","number":1,"children":[{"type":"text","text":"The Lord is my shepherd"},{"type":"noteRef","label":"1","kind":"footnote","noteId":"fn1"},{"type":"text","text":";"}]}]},` +
+				`{"type":"paragraph","children":[` +
+				`{"type":"verse","id":"PSA.23.2","label":"2","number":2,"children":[{"type":"text","text":"He said, "},{"type":"span","kind":"wordsOfJesus","children":[{"type":"text","text":"Follow"},{"type":"noteRef","label":"1","kind":"reference","noteId":"ref1"},{"type":"text","text":" me"}]},{"type":"text","text":"."}]},` +
+				`{"type":"verse","id":"PSA.23.3","label":"3","number":3,"children":[{"type":"text","text":"He restores my soul."}]}]}]},` +
+				`{"type":"chapter","id":"PSA.24","children":[` +
+				`{"type":"psalmTitle","id":"p1","children":[{"type":"text","text":"A Psalm of David."}]},` +
+				`{"type":"paragraph","children":[{"type":"verse","id":"PSA.24.1","label":"1","number":1,"children":[{"type":"text","text":"The earth."}]}]}]}],` +
+				`"notes":[` +
+				`{"id":"fn1","kind":"footnote","label":"1","number":1,"chapterId":"PSA.23","targetId":"PSA.23.1","content":"Or *keeper*"},` +
+				`{"id":"ref1","kind":"reference","label":"1","number":1,"chapterId":"PSA.23","targetId":"PSA.23.2","content":"Mt 4:19"}]}`,
+		},
+		{
+			name: "hidden elements and formatters",
+			options: &RenderOptions{
+				ShowHeadings:     boolPtr(false),
+				ShowPsalmTitles:  boolPtr(false),
+				ShowReferences:   boolPtr(false),
+				ShowWordsOfJesus: boolPtr(false),
+				FootnoteFormatters: map[biblev1.MarkKind]FootnoteFormatFunc{
+					biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark) string {
+						return "Note: " + mark.Content
+					},
+				},
+			},
+			expected: `{"chapters":[` +
+				`{"type":"chapter","id":"PSA.23","children":[` +
+				`{"type":"poetryLine","children":[{"type":"verse","id":"PSA.23.1","label":"1","number":1,"children":[{"type":"text","text":"The Lord is my shepherd"},{"type":"noteRef","label":"1","kind":"footnote","noteId":"fn1"},{"type":"text","text":";"}]}]},` +
+				`{"type":"paragraph","children":[` +
+				`{"type":"verse","id":"PSA.23.2","label":"2","number":2,"children":[{"type":"text","text":"He said, Follow me."}]},` +
+				`{"type":"verse","id":"PSA.23.3","label":"3","number":3,"children":[{"type":"text","text":"He restores my soul."}]}]}]},` +
+				`{"type":"chapter","id":"PSA.24","children":[` +
+				`{"type":"paragraph","children":[{"type":"verse","id":"PSA.24.1","label":"1","number":1,"children":[{"type":"text","text":"The earth."}]}]}]}],` +
+				`"notes":[` +
+				`{"id":"fn1","kind":"footnote","label":"1","number":1,"chapterId":"PSA.23","targetId":"PSA.23.1","content":"Note: Or *keeper*"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := BuildDocument(verses, marks, headings, psalms, tt.options)
+			if err != nil {
+				t.Fatalf("BuildDocument() error = %v", err)
+			}
+
+			result, err := json.Marshal(document)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+
+			if string(result) != tt.expected {
+				t.Errorf("BuildDocument() = %s, want %s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRenderDocument(t *testing.T) {
+	_, _, verses, marks, headings, psalms := usfmTestData()
+
+	document, err := BuildDocument(verses, marks, headings, psalms, nil)
+	if err != nil {
+		t.Fatalf("BuildDocument() error = %v", err)
+	}
+
+	for _, renderer := range []Renderer{&MdRenderer{}, &HtmlRenderer{}, &TextRenderer{}, &LatexRenderer{}} {
+		expected, err := RenderVerses(renderer, verses, marks, headings, psalms, nil)
+		if err != nil {
+			t.Fatalf("RenderVerses() error = %v", err)
+		}
+
+		// NOTE: A document can be rendered to several formats
+		result, err := RenderDocument(renderer, document, nil)
+		if err != nil {
+			t.Fatalf("RenderDocument() error = %v", err)
+		}
+
+		if result != expected {
+			t.Errorf("RenderDocument(%T) = %q, want %q", renderer, result, expected)
+		}
+	}
+}
+
+func TestRenderDocument_JSONRoundTrip(t *testing.T) {
+	_, _, verses, marks, headings, psalms := usfmTestData()
+
+	// NOTE: A footnote covering text, replaced by its reference
+	marks = append(marks, &biblev1.Mark{Id: "fn3", Content: "Or *leads*", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, Label: "c", SortOrder: 2, StartOffset: 3, EndOffset: 11, TargetId: "PSA.23.3", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"})
+
+	tests := []struct {
+		name    string
+		options *RenderOptions
+	}{
+		{
+			name: "default",
+		},
+		{
+			name:    "inline footnotes with letters",
+			options: &RenderOptions{FootnotePlacement: FootnotePlacementInline, FootnoteNumbering: FootnoteNumberingLettersPerChapter},
+		},
+		{
+			name: "custom labels and formatters",
+			options: &RenderOptions{
+				FootnotePlacement: FootnotePlacementEndOfChapter,
+				MarkLabels: map[biblev1.MarkKind]MarkLabelFunc{
+					biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark, chapterId string) string {
+						return "[" + mark.Label + "]"
+					},
+				},
+				FootnoteFormatters: map[biblev1.MarkKind]FootnoteFormatFunc{
+					biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark) string {
+						return "Note: " + mark.Content
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := BuildDocument(verses, marks, headings, psalms, tt.options)
+			if err != nil {
+				t.Fatalf("BuildDocument() error = %v", err)
+			}
+
+			data, err := json.Marshal(document)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+
+			var decoded Document
+
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+
+			for _, renderer := range []Renderer{&MdRenderer{}, &HtmlRenderer{}, &TextRenderer{}, &LatexRenderer{}, &testRenderer{}} {
+				expected, err := RenderVerses(renderer, verses, marks, headings, psalms, tt.options)
+				if err != nil {
+					t.Fatalf("RenderVerses() error = %v", err)
+				}
+
+				result, err := RenderDocument(renderer, &decoded, tt.options)
+				if err != nil {
+					t.Fatalf("RenderDocument() error = %v", err)
+				}
+
+				if result != expected {
+					t.Errorf("RenderDocument(%T) = %q, want %q", renderer, result, expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package utils
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/samber/lo"
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+	"golang.org/x/net/html"
+)
+
+const docxNamespace = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+
+// NOTE: Words of Jesus color, the same red as Zefania
+const docxWordsOfJesusColor = "FF0000"
+
+// NOTE: Element wrapping the HTML of a note, converted to a Word footnote
+const docxNoteTag = "docx-note"
+
+var docxWhitespaceRegex = regexp.MustCompile(`\s+`)
+
+var docxFiles = map[string]string{
+	"[Content_Types].xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>
+<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>
+<Override PartName="/word/settings.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.settings+xml"/>
+<Override PartName="/word/footnotes.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.footnotes+xml"/>
+</Types>
+`,
+	"_rels/.rels": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+</Relationships>
+`,
+	"word/_rels/document.xml.rels": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/settings" Target="settings.xml"/>
+<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/footnotes" Target="footnotes.xml"/>
+</Relationships>
+`,
+	"word/settings.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:settings xmlns:w="` + docxNamespace + `">
+<w:footnotePr><w:footnote w:id="-1"/><w:footnote w:id="0"/></w:footnotePr>
+</w:settings>
+`,
+	"word/styles.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="` + docxNamespace + `">
+<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/><w:pPr><w:spacing w:after="120"/></w:pPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:sz w:val="32"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240"/><w:outlineLvl w:val="1"/></w:pPr><w:rPr><w:b/><w:sz w:val="28"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading3"><w:name w:val="heading 3"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="200"/><w:outlineLvl w:val="2"/></w:pPr><w:rPr><w:b/><w:sz w:val="26"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading4"><w:name w:val="heading 4"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="3"/></w:pPr><w:rPr><w:b/><w:i/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading5"><w:name w:val="heading 5"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="4"/></w:pPr><w:rPr><w:b/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading6"><w:name w:val="heading 6"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="5"/></w:pPr><w:rPr><w:i/></w:rPr></w:style>
+<w:style w:type="paragraph" w:customStyle="1" w:styleId="Poetry"><w:name w:val="Poetry"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="0"/><w:ind w:left="720"/></w:pPr></w:style>
+<w:style w:type="paragraph" w:customStyle="1" w:styleId="PsalmTitle"><w:name w:val="Psalm Title"/><w:basedOn w:val="Normal"/><w:rPr><w:i/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="FootnoteText"><w:name w:val="footnote text"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="0"/></w:pPr><w:rPr><w:sz w:val="20"/></w:rPr></w:style>
+<w:style w:type="character" w:styleId="FootnoteReference"><w:name w:val="footnote reference"/><w:rPr><w:vertAlign w:val="superscript"/></w:rPr></w:style>
+</w:styles>
+`,
+}
+
+// NOTE: Renders notes inline as <docx-note>, they are moved to footnotes.xml
+// by docxWriter
+type docxRenderer struct {
+	*HtmlRenderer
+}
+
+func (r *docxRenderer) Heading(heading *biblev1.Heading, content string) string {
+	level := min(max(int(heading.Level), 1), MaxHeading)
+
+	return fmt.Sprintf("\n<h%d>%s</h%d>\n", level, content, level)
+}
+
+func (r *docxRenderer) PsalmTitle(psalm *biblev1.PsalmMetadata) string {
+	return fmt.Sprintf(`<p class="psalm-title">%s</p>`, r.text(psalm.Text))
+}
+
+func (r *docxRenderer) ChapterBreak() string {
+	return "\n\n<hr>\n\n"
+}
+
+func (r *docxRenderer) InlineFootnote(mark *biblev1.Mark) string {
+	return fmt.Sprintf("<%s>%s</%s>", docxNoteTag, r.text(mark.Content), docxNoteTag)
+}
+
+func (r *docxRenderer) MarkLabels() map[biblev1.MarkKind]MarkLabelFunc {
+	labels := r.HtmlRenderer.MarkLabels()
+
+	labels[biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS] = func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`<span class="woj">%s</span>`, mark.Content)
+	}
+
+	return labels
+}
+
+// NOTE: Formatting of a run, from its enclosing HTML elements
+type docxFormat struct {
+	bold        bool
+	italic      bool
+	red         bool
+	superscript bool
+}
+
+// NOTE: A run of text, a line break or a footnote reference
+type docxRun struct {
+	text       string
+	format     docxFormat
+	lineBreak  bool
+	footnoteId int
+}
+
+// NOTE: Converts the HTML blocks of RenderVerses to WordprocessingML
+type docxWriter struct {
+	body       strings.Builder
+	footnotes  strings.Builder
+	footnoteId int
+}
+
+// NOTE: Collects the runs of node and its children
+func (d *docxWriter) runs(node *html.Node, format docxFormat, runs []docxRun) []docxRun {
+	switch node.Type {
+	case html.TextNode:
+		return append(runs, docxRun{text: node.Data, format: format})
+	case html.ElementNode:
+	default:
+		return runs
+	}
+
+	switch node.Data {
+	case "b", "strong":
+		format.bold = true
+	case "i", "em":
+		format.italic = true
+	case "sup":
+		format.superscript = true
+	case "span":
+		if htmlAttr(node, "class") == "woj" {
+			format.red = true
+		}
+	case "br":
+		return append(runs, docxRun{lineBreak: true, format: format})
+	case docxNoteTag:
+		d.footnoteId++
+
+		d.footnotes.WriteString(fmt.Sprintf(`<w:footnote w:id="%d">`, d.footnoteId))
+		d.footnotes.WriteString(`<w:p><w:pPr><w:pStyle w:val="FootnoteText"/></w:pPr>`)
+		d.footnotes.WriteString(`<w:r><w:rPr><w:rStyle w:val="FootnoteReference"/></w:rPr><w:footnoteRef/></w:r><w:r><w:t xml:space="preserve"> </w:t></w:r>`)
+
+		noteRuns := make([]docxRun, 0)
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			noteRuns = d.runs(child, docxFormat{}, noteRuns)
+		}
+
+		d.footnotes.WriteString(docxRuns(noteRuns))
+		d.footnotes.WriteString("</w:p></w:footnote>\n")
+
+		return append(runs, docxRun{footnoteId: d.footnoteId})
+	}
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		runs = d.runs(child, format, runs)
+	}
+
+	return runs
+}
+
+// NOTE: Writes a block as a paragraph with the given style, the default style
+// if empty
+func (d *docxWriter) paragraph(block *html.Node, style string) {
+	runs := make([]docxRun, 0)
+
+	for child := block.FirstChild; child != nil; child = child.NextSibling {
+		runs = d.runs(child, docxFormat{}, runs)
+	}
+
+	d.body.WriteString("<w:p>")
+
+	if style != "" {
+		d.body.WriteString(fmt.Sprintf(`<w:pPr><w:pStyle w:val="%s"/></w:pPr>`, style))
+	}
+
+	d.body.WriteString(docxRuns(runs))
+	d.body.WriteString("</w:p>\n")
+}
+
+func (d *docxWriter) block(block *html.Node) {
+	switch block.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		d.paragraph(block, "Heading"+block.Data[1:])
+	case "blockquote":
+		d.paragraph(block, "Poetry")
+	case "p":
+		d.paragraph(block, lo.Ternary(htmlAttr(block, "class") == "psalm-title", "PsalmTitle", ""))
+	case "hr":
+		d.body.WriteString("<w:p/>\n")
+	default:
+		d.paragraph(block, "")
+	}
+}
+
+// NOTE: Writes runs with collapsed whitespace, without leading and trailing
+// spaces
+func docxRuns(runs []docxRun) string {
+	var sb strings.Builder
+
+	// NOTE: Whether the previous text ends with a space, true at the start to
+	// trim the leading spaces
+	space := true
+
+	for i, run := range runs {
+		if run.footnoteId > 0 {
+			sb.WriteString(fmt.Sprintf(`<w:r><w:rPr><w:rStyle w:val="FootnoteReference"/></w:rPr><w:footnoteReference w:id="%d"/></w:r>`, run.footnoteId))
+
+			space = false
+
+			continue
+		}
+
+		if run.lineBreak {
+			sb.WriteString("<w:r><w:br/></w:r>")
+
+			space = true
+
+			continue
+		}
+
+		text := docxWhitespaceRegex.ReplaceAllString(run.text, " ")
+
+		if space {
+			text = strings.TrimLeft(text, " ")
+		}
+
+		// NOTE: Trailing spaces of the last text
+		if !slices.ContainsFunc(runs[i+1:], func(next docxRun) bool {
+			return next.footnoteId > 0 || next.lineBreak || strings.TrimSpace(next.text) != ""
+		}) {
+			text = strings.TrimRight(text, " ")
+		}
+
+		if text == "" {
+			continue
+		}
+
+		space = strings.HasSuffix(text, " ")
+
+		sb.WriteString("<w:r>")
+
+		var rPr strings.Builder
+
+		// NOTE: In the element order of the schema
+		if run.format.bold {
+			rPr.WriteString("<w:b/>")
+		}
+
+		if run.format.italic {
+			rPr.WriteString("<w:i/>")
+		}
+
+		if run.format.red {
+			rPr.WriteString(fmt.Sprintf(`<w:color w:val="%s"/>`, docxWordsOfJesusColor))
+		}
+
+		if run.format.superscript {
+			rPr.WriteString(`<w:vertAlign w:val="superscript"/>`)
+		}
+
+		if rPr.Len() > 0 {
+			sb.WriteString("<w:rPr>" + rPr.String() + "</w:rPr>")
+		}
+
+		sb.WriteString(fmt.Sprintf(`<w:t xml:space="preserve">%s</w:t></w:r>`, htmlEscaper.Replace(text)))
+	}
+
+	return sb.String()
+}
+
+// ExportDocx writes verses as a Word document to w, from the same inputs as
+// ProcessVerseMd. Headings use the built-in heading styles, poetry the
+// "Poetry" style, footnotes and references are Word footnotes and words of
+// Jesus are red. Footnotes are always placed by Word, FootnotePlacement is
+// ignored.
+func ExportDocx(w io.Writer, verses []*biblev1.Verse, marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata, options ...*RenderOptions) error {
+	renderOptions := &RenderOptions{}
+	if option := lo.FirstOrEmpty(options); option != nil {
+		copied := *option
+		renderOptions = &copied
+	}
+
+	renderOptions.FootnotePlacement = FootnotePlacementInline
+
+	content, err := RenderVerses(&docxRenderer{HtmlRenderer: &HtmlRenderer{}}, verses, marks, headings, psalms, renderOptions)
+	if err != nil {
+		return err
+	}
+
+	blocks, err := htmlBlocks(content)
+	if err != nil {
+		return err
+	}
+
+	d := &docxWriter{}
+
+	for _, block := range blocks {
+		d.block(block)
+	}
+
+	// NOTE: Word requires a paragraph in the body
+	if d.body.Len() == 0 {
+		d.body.WriteString("<w:p/>\n")
+	}
+
+	files := map[string]string{
+		"word/document.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+			fmt.Sprintf(`<w:document xmlns:w="%s"><w:body>`, docxNamespace) + "\n" +
+			d.body.String() +
+			"</w:body></w:document>\n",
+		"word/footnotes.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+			fmt.Sprintf(`<w:footnotes xmlns:w="%s">`, docxNamespace) + "\n" +
+			`<w:footnote w:type="separator" w:id="-1"><w:p><w:r><w:separator/></w:r></w:p></w:footnote>` + "\n" +
+			`<w:footnote w:type="continuationSeparator" w:id="0"><w:p><w:r><w:continuationSeparator/></w:r></w:p></w:footnote>` + "\n" +
+			d.footnotes.String() +
+			"</w:footnotes>\n",
+	}
+
+	for name, content := range docxFiles {
+		files[name] = content
+	}
+
+	zipWriter := zip.NewWriter(w)
+
+	// NOTE: Sorted so the archive is reproducible
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		file, err := zipWriter.Create(name)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(file, files[name]); err != nil {
+			return err
+		}
+	}
+
+	return zipWriter.Close()
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func TestExportDocx(t *testing.T) {
+	_, _, verses, marks, headings, psalms := usfmTestData()
+
+	tests := []struct {
+		name     string
+		options  *RenderOptions
+		expected map[string][]string
+	}{
+		{
+			name: "default",
+			expected: map[string][]string{
+				"word/document.xml": {
+					`<w:p><w:pPr><w:pStyle w:val="Heading1"/></w:pPr><w:r><w:t xml:space="preserve">The Good</w:t></w:r><w:r><w:rPr><w:rStyle w:val="FootnoteReference"/></w:rPr><w:footnoteReference w:id="1"/></w:r><w:r><w:t xml:space="preserve"> Shepherd</w:t></w:r></w:p>`,
+					`<w:p><w:pPr><w:pStyle w:val="PsalmTitle"/></w:pPr><w:r><w:t xml:space="preserve">A Psalm of David.</w:t></w:r></w:p>`,
+					`<w:p><w:pPr><w:pStyle w:val="Poetry"/></w:pPr><w:r><w:rPr><w:b/><w:vertAlign w:val="superscript"/></w:rPr><w:t xml:space="preserve">1</w:t></w:r><w:r><w:t xml:space="preserve"> The Lord is my </w:t></w:r><w:r><w:rPr><w:i/></w:rPr><w:t xml:space="preserve">shepherd</w:t></w:r><w:r><w:rPr><w:rStyle w:val="FootnoteReference"/></w:rPr><w:footnoteReference w:id="2"/></w:r><w:r><w:t xml:space="preserve">;</w:t></w:r></w:p>`,
+					`<w:r><w:t xml:space="preserve"> He said, </w:t></w:r><w:r><w:rPr><w:color w:val="FF0000"/></w:rPr><w:t xml:space="preserve">Follow</w:t></w:r><w:r><w:rPr><w:rStyle w:val="FootnoteReference"/></w:rPr><w:footnoteReference w:id="3"/></w:r><w:r><w:rPr><w:color w:val="FF0000"/></w:rPr><w:t xml:space="preserve"> me</w:t></w:r>`,
+					"<w:p/>\n" + `<w:p><w:pPr><w:pStyle w:val="Heading5"/></w:pPr>`,
+				},
+				"word/footnotes.xml": {
+					`<w:footnote w:id="2"><w:p><w:pPr><w:pStyle w:val="FootnoteText"/></w:pPr><w:r><w:rPr><w:rStyle w:val="FootnoteReference"/></w:rPr><w:footnoteRef/></w:r><w:r><w:t xml:space="preserve"> </w:t></w:r><w:r><w:t xml:space="preserve">Or </w:t></w:r><w:r><w:rPr><w:i/></w:rPr><w:t xml:space="preserve">keeper</w:t></w:r></w:p></w:footnote>`,
+					`<w:t xml:space="preserve">Mt 4:19</w:t>`,
+				},
+			},
+		},
+		{
+			name: "hidden references with formatters",
+			options: &RenderOptions{
+				ShowReferences:   boolPtr(false),
+				ShowWordsOfJesus: boolPtr(false),
+				FootnoteFormatters: map[biblev1.MarkKind]FootnoteFormatFunc{
+					biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark) string {
+						return "Note: " + mark.Content
+					},
+				},
+			},
+			expected: map[string][]string{
+				"word/document.xml": {
+					`<w:r><w:t xml:space="preserve"> He said, Follow me.</w:t></w:r>`,
+				},
+				"word/footnotes.xml": {
+					`<w:t xml:space="preserve">Note: Or life</w:t>`,
+					`<w:t xml:space="preserve">Note: Or </w:t>`,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			if err := ExportDocx(&buf, verses, marks, headings, psalms, tt.options); err != nil {
+				t.Fatalf("ExportDocx() error = %v", err)
+			}
+
+			_, files := readZip(t, buf.Bytes())
+
+			for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "word/_rels/document.xml.rels", "word/document.xml", "word/footnotes.xml", "word/settings.xml", "word/styles.xml"} {
+				decoder := xml.NewDecoder(strings.NewReader(files[name]))
+
+				for {
+					_, err := decoder.Token()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+
+					if err != nil {
+						t.Errorf("%s is not well-formed: %v", name, err)
+
+						break
+					}
+				}
+			}
+
+			for name, expected := range tt.expected {
+				for _, part := range expected {
+					if !strings.Contains(files[name], part) {
+						t.Errorf("%s does not contain %q:\n%s", name, part, files[name])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExportDocx_Empty(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := ExportDocx(&buf, nil, nil, nil, nil); err != nil {
+		t.Fatalf("ExportDocx() error = %v", err)
+	}
+
+	_, files := readZip(t, buf.Bytes())
+
+	if !strings.Contains(files["word/document.xml"], "<w:body>\n<w:p/>\n</w:body>") {
+		t.Errorf("word/document.xml = %q, want an empty paragraph", files["word/document.xml"])
+	}
+}
@@ -0,0 +1,352 @@
+package utils
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+	"golang.org/x/net/html"
+)
+
+var ErrEpubNoChapters = errors.New("epub has no chapters")
+
+const epubStyle = `@namespace epub "http://www.idpf.org/2007/ops";
+
+body { line-height: 1.5; }
+sup { line-height: 0; }
+blockquote { margin: 0 0 0 2em; }
+a[epub|type~="noteref"] { text-decoration: none; }
+aside[epub|type~="footnote"] { font-size: 0.9em; }
+.footnotes { border-top: 1px solid; margin-top: 2em; }
+`
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>
+`
+
+// NOTE: Characters not allowed in an XML id
+var epubIdRegex = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// EpubOptions sets the metadata of ExportEpub.
+type EpubOptions struct {
+	Title string
+	// NOTE: BCP 47 language tag, "en" if empty
+	Language string
+	Rights   string
+	// NOTE: Unique identifier of the publication, a "urn:uuid:" derived from
+	// the title and the book codes if empty
+	Identifier string
+	// NOTE: Last modification time, the current time if zero
+	Modified time.Time
+	// NOTE: Options of the rendered chapters, footnotes are placed at the end
+	// of each chapter unless FootnotePlacementInline is set
+	RenderOptions *RenderOptions
+	// NOTE: Renderer of the chapters, only Unsafe and Policy are used
+	Renderer *HtmlRenderer
+}
+
+// NOTE: Renders chapters like ProcessVerseHtml, with EPUB footnotes and
+// heading anchors
+type epubRenderer struct {
+	*HtmlRenderer
+}
+
+// NOTE: Returns a valid XML id, e.g. "fn-1-PSA.23"
+func epubId(format string, a ...any) string {
+	return epubIdRegex.ReplaceAllString(fmt.Sprintf(format, a...), "-")
+}
+
+func (r *epubRenderer) MarkLabels() map[biblev1.MarkKind]MarkLabelFunc {
+	labels := r.HtmlRenderer.MarkLabels()
+
+	labels[biblev1.MarkKind_MARK_KIND_FOOTNOTE] = func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`<sup><a epub:type="noteref" href="#%s" id="%s">%s</a></sup>`, epubId("fn-%d-%s", mark.SortOrder+1, chapterId), epubId("fnref-%d-%s", mark.SortOrder+1, chapterId), htmlEscaper.Replace(footnoteLabel(mark)))
+	}
+	labels[biblev1.MarkKind_MARK_KIND_REFERENCE] = func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`<sup><a epub:type="noteref" href="#%s" id="%s">%s@</a></sup>`, epubId("ref-%d-%s", mark.SortOrder+1, chapterId), epubId("refref-%d-%s", mark.SortOrder+1, chapterId), htmlEscaper.Replace(footnoteLabel(mark)))
+	}
+
+	return labels
+}
+
+func (r *epubRenderer) Verse(verse *biblev1.Verse, content string) string {
+	return fmt.Sprintf(`<span id="%s" data-verse="%d" data-chapter="%s">%s</span>`, epubId("v-%s", verse.Id), verse.Number, htmlEscaper.Replace(verse.ChapterId), content)
+}
+
+func (r *epubRenderer) Heading(heading *biblev1.Heading, content string) string {
+	// NOTE: <h1> is the chapter title
+	level := min(max(int(heading.Level), 1)+1, MaxHeading)
+
+	return fmt.Sprintf(`<h%d id="%s">%s</h%d>`, level, epubId("h-%s", heading.Id), content, level)
+}
+
+func (r *epubRenderer) Footnote(mark *biblev1.Mark) string {
+	switch mark.Kind {
+	case biblev1.MarkKind_MARK_KIND_FOOTNOTE:
+		return fmt.Sprintf(`<aside epub:type="footnote" id="%s"><p><a href="#%s">%s</a> %s</p></aside>`, epubId("fn-%d-%s", mark.SortOrder+1, mark.ChapterId), epubId("fnref-%d-%s", mark.SortOrder+1, mark.ChapterId), htmlEscaper.Replace(footnoteLabel(mark)), r.text(mark.Content))
+	case biblev1.MarkKind_MARK_KIND_REFERENCE:
+		return fmt.Sprintf(`<aside epub:type="footnote" id="%s"><p><a href="#%s">%s@</a> %s</p></aside>`, epubId("ref-%d-%s", mark.SortOrder+1, mark.ChapterId), epubId("refref-%d-%s", mark.SortOrder+1, mark.ChapterId), htmlEscaper.Replace(footnoteLabel(mark)), r.text(mark.Content))
+	default:
+		return ""
+	}
+}
+
+func (r *epubRenderer) FootnoteSection(footnotes []string) string {
+	if len(footnotes) == 0 {
+		return ""
+	}
+
+	return "\n\n<section class=\"footnotes\">" + strings.Join(footnotes, "\n") + "</section>"
+}
+
+// NOTE: Converts the HTML of RenderVerses to XHTML
+func epubXhtml(fragment string) (string, error) {
+	blocks, err := htmlBlocks(fragment)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+
+	for _, block := range blocks {
+		if err := html.Render(&sb, block); err != nil {
+			return "", err
+		}
+
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
+
+// NOTE: Wraps body in an XHTML content document
+func epubDocument(title, language, body string) string {
+	language = htmlEscaper.Replace(language)
+
+	return `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		"<!DOCTYPE html>\n" +
+		fmt.Sprintf(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="%s" xml:lang="%s">`, language, language) + "\n" +
+		fmt.Sprintf(`<head><meta charset="UTF-8"/><title>%s</title><link rel="stylesheet" type="text/css" href="style.css"/></head>`, htmlEscaper.Replace(title)) + "\n" +
+		"<body>\n" + body + "</body>\n</html>\n"
+}
+
+// NOTE: Returns a name-based (version 5) UUID URN of name
+func epubIdentifier(name string) string {
+	hash := sha1.Sum([]byte(name))
+
+	hash[6] = (hash[6] & 0x0f) | 0x50
+	hash[8] = (hash[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", hash[0:4], hash[4:6], hash[6:8], hash[8:10], hash[10:16])
+}
+
+type epubChapter struct {
+	file     string
+	title    string
+	headings []*biblev1.Heading
+}
+
+// ExportEpub writes books as an EPUB 3 publication to w. Each chapter is an
+// XHTML file rendered like ProcessVerseHtml, with footnotes and references as
+// <aside epub:type="footnote"> so reading systems can show them as pop-ups.
+// The navigation document lists the books, chapters and headings.
+func ExportEpub(w io.Writer, books []*BookData, options *EpubOptions) error {
+	if options == nil {
+		options = &EpubOptions{}
+	}
+
+	language := lo.Ternary(options.Language != "", options.Language, "en")
+	modified := lo.Ternary(options.Modified.IsZero(), time.Now(), options.Modified)
+
+	renderOptions := &RenderOptions{}
+	if options.RenderOptions != nil {
+		copied := *options.RenderOptions
+		renderOptions = &copied
+	}
+
+	// NOTE: Footnote ids are only unique in one chapter file
+	if renderOptions.FootnotePlacement != FootnotePlacementInline {
+		renderOptions.FootnotePlacement = FootnotePlacementEndOfPassage
+	}
+
+	renderer := &epubRenderer{HtmlRenderer: lo.Ternary(options.Renderer != nil, options.Renderer, &HtmlRenderer{})}
+
+	files := make(map[string]string)
+	chaptersByBook := make([][]epubChapter, len(books))
+	codes := make([]string, 0, len(books))
+
+	for i, book := range books {
+		name := ""
+		if book.Book != nil {
+			name = lo.Ternary(book.Book.Name != "", book.Book.Name, book.Book.Code)
+			codes = append(codes, book.Book.Code)
+		}
+
+		versesByChapter := lo.GroupBy(book.Verses, func(v *biblev1.Verse) string {
+			return v.ChapterId
+		})
+		headingsByVerse := lo.GroupBy(book.Headings, func(h *biblev1.Heading) string {
+			return h.VerseId
+		})
+		markIndex := NewMarkIndex(book.Marks)
+
+		for _, chapter := range book.Chapters {
+			verses := versesByChapter[chapter.Id]
+			if len(verses) == 0 {
+				continue
+			}
+
+			// NOTE: Marks and headings are picked by the verse they target, so
+			// the footnotes of other chapters are not listed in this one
+			chapterMarks := make([]*biblev1.Mark, 0)
+			chapterHeadings := make([]*biblev1.Heading, 0)
+
+			for _, verse := range verses {
+				chapterMarks = append(chapterMarks, markIndex.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, verse.Id)...)
+
+				for _, heading := range headingsByVerse[verse.Id] {
+					chapterHeadings = append(chapterHeadings, heading)
+					chapterMarks = append(chapterMarks, markIndex.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_HEADING, heading.Id)...)
+				}
+			}
+
+			content, err := RenderVerses(renderer, verses, chapterMarks, chapterHeadings, book.Psalms, renderOptions)
+			if err != nil {
+				return err
+			}
+
+			body, err := epubXhtml(content)
+			if err != nil {
+				return err
+			}
+
+			title := strings.TrimSpace(fmt.Sprintf("%s %d", name, chapter.Number))
+			file := fmt.Sprintf("book%d-chapter%d.xhtml", i+1, chapter.Number)
+
+			files[file] = epubDocument(title, language, fmt.Sprintf(`<section epub:type="chapter"><h1>%s</h1>`, htmlEscaper.Replace(title))+"\n"+body+"</section>\n")
+
+			chaptersByBook[i] = append(chaptersByBook[i], epubChapter{
+				file:     file,
+				title:    title,
+				headings: chapterHeadings,
+			})
+		}
+	}
+
+	if len(files) == 0 {
+		return ErrEpubNoChapters
+	}
+
+	identifier := options.Identifier
+	if identifier == "" {
+		identifier = epubIdentifier(options.Title + "\x00" + strings.Join(codes, "\x00"))
+	}
+
+	var nav, manifest, spine strings.Builder
+
+	nav.WriteString(fmt.Sprintf(`<nav epub:type="toc" id="toc"><h1>%s</h1>`, htmlEscaper.Replace(options.Title)) + "\n<ol>\n")
+
+	for i, chapters := range chaptersByBook {
+		if len(chapters) == 0 {
+			continue
+		}
+
+		bookTitle := ""
+		if books[i].Book != nil {
+			bookTitle = lo.Ternary(books[i].Book.Name != "", books[i].Book.Name, books[i].Book.Code)
+		}
+
+		nav.WriteString(fmt.Sprintf(`<li><a href="%s">%s</a>`, chapters[0].file, htmlEscaper.Replace(bookTitle)) + "\n<ol>\n")
+
+		for _, chapter := range chapters {
+			id := strings.TrimSuffix(chapter.file, ".xhtml")
+
+			manifest.WriteString(fmt.Sprintf(`<item id="%s" href="%s" media-type="application/xhtml+xml"/>`, id, chapter.file) + "\n")
+			spine.WriteString(fmt.Sprintf(`<itemref idref="%s"/>`, id) + "\n")
+
+			nav.WriteString(fmt.Sprintf(`<li><a href="%s">%s</a>`, chapter.file, htmlEscaper.Replace(chapter.title)))
+
+			if len(chapter.headings) > 0 {
+				nav.WriteString("\n<ol>\n")
+
+				for _, heading := range chapter.headings {
+					nav.WriteString(fmt.Sprintf(`<li><a href="%s#%s">%s</a></li>`, chapter.file, epubId("h-%s", heading.Id), htmlEscaper.Replace(mdToText(heading.Text))) + "\n")
+				}
+
+				nav.WriteString("</ol>\n")
+			}
+
+			nav.WriteString("</li>\n")
+		}
+
+		nav.WriteString("</ol>\n</li>\n")
+	}
+
+	nav.WriteString("</ol>\n</nav>\n")
+
+	files["nav.xhtml"] = epubDocument(options.Title, language, nav.String())
+	files["style.css"] = epubStyle
+
+	var opf strings.Builder
+
+	opf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	opf.WriteString(fmt.Sprintf(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="pub-id" xml:lang="%s">`, htmlEscaper.Replace(language)) + "\n")
+	opf.WriteString(`<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	opf.WriteString(fmt.Sprintf(`<dc:identifier id="pub-id">%s</dc:identifier>`, htmlEscaper.Replace(identifier)) + "\n")
+	opf.WriteString(fmt.Sprintf(`<dc:title>%s</dc:title>`, htmlEscaper.Replace(options.Title)) + "\n")
+	opf.WriteString(fmt.Sprintf(`<dc:language>%s</dc:language>`, htmlEscaper.Replace(language)) + "\n")
+
+	if options.Rights != "" {
+		opf.WriteString(fmt.Sprintf(`<dc:rights>%s</dc:rights>`, htmlEscaper.Replace(options.Rights)) + "\n")
+	}
+
+	opf.WriteString(fmt.Sprintf(`<meta property="dcterms:modified">%s</meta>`, modified.UTC().Format("2006-01-02T15:04:05Z")) + "\n")
+	opf.WriteString("</metadata>\n<manifest>\n")
+	opf.WriteString(`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+	opf.WriteString(`<item id="style" href="style.css" media-type="text/css"/>` + "\n")
+	opf.WriteString(manifest.String())
+	opf.WriteString("</manifest>\n<spine>\n")
+	opf.WriteString(spine.String())
+	opf.WriteString("</spine>\n</package>\n")
+
+	files["content.opf"] = opf.String()
+
+	zipWriter := zip.NewWriter(w)
+
+	// NOTE: The mimetype must be the first entry, stored without compression
+	mimetype, err := zipWriter.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	files["META-INF/container.xml"] = epubContainer
+
+	// NOTE: Sorted so the archive is reproducible
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		file, err := zipWriter.Create(lo.Ternary(strings.HasPrefix(name, "META-INF/"), name, "OEBPS/"+name))
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(file, files[name]); err != nil {
+			return err
+		}
+	}
+
+	return zipWriter.Close()
+}
@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func epubTestBooks() []*BookData {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+
+	// NOTE: Footnote numbers must be unique in a chapter
+	marks[3].SortOrder = 1
+
+	return []*BookData{{
+		Book:     book,
+		Chapters: chapters,
+		Verses:   verses,
+		Marks:    marks,
+		Headings: headings,
+		Psalms:   psalms,
+	}}
+}
+
+func readZip(t *testing.T, data []byte) (*zip.Reader, map[string]string) {
+	t.Helper()
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+
+	files := make(map[string]string)
+
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", file.Name, err)
+		}
+
+		content, err := io.ReadAll(rc)
+		rc.Close()
+
+		if err != nil {
+			t.Fatalf("ReadAll(%s) error = %v", file.Name, err)
+		}
+
+		files[file.Name] = string(content)
+	}
+
+	return reader, files
+}
+
+func TestExportEpub(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := ExportEpub(&buf, epubTestBooks(), &EpubOptions{
+		Title:    "Psalms & Hymns",
+		Language: "vi",
+		Rights:   "Public domain",
+		Modified: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("ExportEpub() error = %v", err)
+	}
+
+	reader, files := readZip(t, buf.Bytes())
+
+	if first := reader.File[0]; first.Name != "mimetype" || first.Method != zip.Store || files["mimetype"] != "application/epub+zip" {
+		t.Errorf("first entry = %s (method %d), want stored mimetype", first.Name, first.Method)
+	}
+
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml", "OEBPS/style.css", "OEBPS/book1-chapter23.xhtml", "OEBPS/book1-chapter24.xhtml"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("missing %s", name)
+		}
+	}
+
+	for name, content := range files {
+		if !strings.HasSuffix(name, ".xml") && !strings.HasSuffix(name, ".xhtml") && !strings.HasSuffix(name, ".opf") {
+			continue
+		}
+
+		decoder := xml.NewDecoder(strings.NewReader(content))
+
+		for {
+			_, err := decoder.Token()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			if err != nil {
+				t.Errorf("%s is not well-formed: %v", name, err)
+
+				break
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		expected []string
+	}{
+		{
+			name: "metadata",
+			file: "OEBPS/content.opf",
+			expected: []string{
+				`<dc:title>Psalms &amp; Hymns</dc:title>`,
+				`<dc:language>vi</dc:language>`,
+				`<dc:rights>Public domain</dc:rights>`,
+				`<dc:identifier id="pub-id">urn:uuid:`,
+				`<meta property="dcterms:modified">2024-01-02T03:04:05Z</meta>`,
+				`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>`,
+				"<itemref idref=\"book1-chapter23\"/>\n<itemref idref=\"book1-chapter24\"/>",
+			},
+		},
+		{
+			name: "navigation",
+			file: "OEBPS/nav.xhtml",
+			expected: []string{
+				`<nav epub:type="toc" id="toc">`,
+				`<li><a href="book1-chapter23.xhtml">Psalms</a>`,
+				`<li><a href="book1-chapter23.xhtml">Psalms 23</a>`,
+				`<li><a href="book1-chapter23.xhtml#h-h1">The Good Shepherd</a></li>`,
+				`<li><a href="book1-chapter24.xhtml#h-h2">The King of Glory</a></li>`,
+			},
+		},
+		{
+			name: "chapter",
+			file: "OEBPS/book1-chapter23.xhtml",
+			expected: []string{
+				`lang="vi" xml:lang="vi"`,
+				`<section epub:type="chapter"><h1>Psalms 23</h1>`,
+				`<h2 id="h-h1">The Good<sup><a epub:type="noteref" href="#fn-2-PSA.23" id="fnref-2-PSA.23">2</a></sup> Shepherd</h2>`,
+				`<p><i>A Psalm of David.</i>`,
+				`<blockquote><span id="v-PSA.23.1" data-verse="1" data-chapter="PSA.23"><sup><b>1</b></sup> The Lord is my <em>shepherd</em><sup><a epub:type="noteref" href="#fn-1-PSA.23" id="fnref-1-PSA.23">1</a></sup>;</span></blockquote>`,
+				`<p><span id="v-PSA.23.3" data-verse="3" data-chapter="PSA.23"><sup><b>3</b></sup> He restores my soul.</span></p>`,
+				`<aside epub:type="footnote" id="fn-1-PSA.23"><p><a href="#fnref-1-PSA.23">1</a> Or <em>keeper</em></p></aside>`,
+				`<b>Follow<sup><a epub:type="noteref" href="#ref-1-PSA.23" id="refref-1-PSA.23">1@</a></sup> me</b>`,
+				`<aside epub:type="footnote" id="ref-1-PSA.23"><p><a href="#refref-1-PSA.23">1@</a> Mt 4:19</p></aside>`,
+			},
+		},
+		{
+			name: "heading level",
+			file: "OEBPS/book1-chapter24.xhtml",
+			expected: []string{
+				`<h6 id="h-h2">The King of Glory</h6>`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, expected := range tt.expected {
+				if !strings.Contains(files[tt.file], expected) {
+					t.Errorf("%s does not contain %q:\n%s", tt.file, expected, files[tt.file])
+				}
+			}
+		})
+	}
+}
+
+func TestExportEpub_MarksByTarget(t *testing.T) {
+	books := epubTestBooks()
+
+	// NOTE: A footnote whose ChapterId is not the chapter of its verse
+	books[0].Marks[0].ChapterId = "PSA.24"
+
+	var buf bytes.Buffer
+
+	if err := ExportEpub(&buf, books, nil); err != nil {
+		t.Fatalf("ExportEpub() error = %v", err)
+	}
+
+	_, files := readZip(t, buf.Bytes())
+
+	if !strings.Contains(files["OEBPS/book1-chapter23.xhtml"], "Or <em>keeper</em>") {
+		t.Errorf("OEBPS/book1-chapter23.xhtml does not contain the footnote of its verse:\n%s", files["OEBPS/book1-chapter23.xhtml"])
+	}
+
+	if strings.Contains(files["OEBPS/book1-chapter24.xhtml"], "Or <em>keeper</em>") {
+		t.Errorf("OEBPS/book1-chapter24.xhtml contains the footnote of another chapter:\n%s", files["OEBPS/book1-chapter24.xhtml"])
+	}
+}
+
+func TestExportEpub_Reproducible(t *testing.T) {
+	options := &EpubOptions{Title: "Psalms", Modified: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	var first, second bytes.Buffer
+
+	if err := ExportEpub(&first, epubTestBooks(), options); err != nil {
+		t.Fatalf("ExportEpub() error = %v", err)
+	}
+
+	if err := ExportEpub(&second, epubTestBooks(), options); err != nil {
+		t.Fatalf("ExportEpub() error = %v", err)
+	}
+
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("ExportEpub() is not reproducible")
+	}
+}
+
+func TestExportEpub_NoChapters(t *testing.T) {
+	err := ExportEpub(io.Discard, []*BookData{}, nil)
+	if !errors.Is(err, ErrEpubNoChapters) {
+		t.Errorf("ExportEpub() error = %v, want %v", err, ErrEpubNoChapters)
+	}
+}
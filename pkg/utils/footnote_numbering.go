@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+// FootnoteNumbering selects the labels of footnotes and references.
+// Footnotes and references are counted separately, and anchor ids keep using
+// SortOrder and ChapterId, so they stay unique whatever the labels are.
+//
+// The label is given to the label functions and Renderer.Footnote in
+// mark.Label. With FootnoteNumberingSortOrder, functions of
+// RenderOptions.MarkLabels and FootnoteFormatters keep the stored Label.
+// MdRenderer keeps its footnote keys, GFM footnotes are numbered by the
+// Markdown processor.
+type FootnoteNumbering int
+
+const (
+	// NOTE: SortOrder + 1, Mark.Label is ignored
+	FootnoteNumberingSortOrder FootnoteNumbering = iota
+	// NOTE: Mark.Label as stored
+	FootnoteNumberingMarkLabel
+	// NOTE: a, b, … z, aa, ab, … restarting at each chapter
+	FootnoteNumberingLettersPerChapter
+	// NOTE: a, b, … z, aa, ab, … across the passage
+	FootnoteNumberingLettersPerPassage
+	// NOTE: 1, 2, 3, … across the passage
+	FootnoteNumberingContinuous
+	// NOTE: *, †, ‡, §, ‖, ¶, then doubled, restarting at each chapter
+	FootnoteNumberingSymbols
+)
+
+var footnoteSymbols = []string{"*", "†", "‡", "§", "‖", "¶"}
+
+// NOTE: Label of the default numbering
+func sortOrderLabel(mark *biblev1.Mark) string {
+	return strconv.Itoa(int(mark.SortOrder) + 1)
+}
+
+// NOTE: Label shown by the built-in renderers, marks labelled by a numbering
+// scheme carry it in Label, other marks use their SortOrder
+func footnoteLabel(mark *biblev1.Mark) string {
+	if mark.Label != "" {
+		return mark.Label
+	}
+
+	return sortOrderLabel(mark)
+}
+
+// NOTE: Returns mark with the label of the default numbering, marks of the
+// other schemes are already labelled by BuildDocument
+func numberedMark(mark *biblev1.Mark, numbering FootnoteNumbering) *biblev1.Mark {
+	if numbering != FootnoteNumberingSortOrder {
+		return mark
+	}
+
+	newMark := cloneMark(mark)
+	newMark.Label = sortOrderLabel(mark)
+
+	return newMark
+}
+
+// NOTE: Returns the n-th label of a, b, … z, aa, ab, …, starting from 1
+func letterLabel(n int) string {
+	label := make([]byte, 0)
+
+	for n > 0 {
+		n--
+		label = append(label, byte('a'+n%26))
+		n /= 26
+	}
+
+	slices.Reverse(label)
+
+	return string(label)
+}
+
+// NOTE: Returns the n-th label of *, †, ‡, §, ‖, ¶, **, ††, …, starting from 1
+func symbolLabel(n int) string {
+	return strings.Repeat(footnoteSymbols[(n-1)%len(footnoteSymbols)], (n-1)/len(footnoteSymbols)+1)
+}
+
+// NOTE: Returns marks in the order of chapterIds then SortOrder, marks of
+// other chapters come last
+func footnoteOrder(marks []*biblev1.Mark, chapterIds []string) []*biblev1.Mark {
+	chapterIndex := func(mark *biblev1.Mark) int {
+		if idx := slices.Index(chapterIds, mark.ChapterId); idx >= 0 {
+			return idx
+		}
+
+		return len(chapterIds)
+	}
+
+	return slices.SortedStableFunc(slices.Values(marks), func(a, b *biblev1.Mark) int {
+		return cmp.Or(cmp.Compare(chapterIndex(a), chapterIndex(b)), cmp.Compare(a.SortOrder, b.SortOrder))
+	})
+}
+
+// NOTE: Counter of the labels of a mark kind, per chapter for the schemes
+// restarting at each chapter
+type footnoteCounterKey struct {
+	kind      biblev1.MarkKind
+	chapterId string
+}
+
+// NOTE: Returns the labels of marks keyed by mark, numbered in footnoteOrder.
+// counters holds the last number of each counter, so passages rendered in
+// parts continue their numbering.
+func footnoteLabels(marks []*biblev1.Mark, chapterIds []string, numbering FootnoteNumbering, counters map[footnoteCounterKey]int) map[*biblev1.Mark]string {
+	labels := make(map[*biblev1.Mark]string, len(marks))
+
+	for _, mark := range footnoteOrder(marks, chapterIds) {
+		key := footnoteCounterKey{kind: mark.Kind}
+
+		if numbering == FootnoteNumberingLettersPerChapter || numbering == FootnoteNumberingSymbols {
+			key.chapterId = mark.ChapterId
+		}
+
+		counters[key]++
+		n := counters[key]
+
+		switch numbering {
+		case FootnoteNumberingMarkLabel:
+			labels[mark] = footnoteLabel(mark)
+		case FootnoteNumberingLettersPerChapter, FootnoteNumberingLettersPerPassage:
+			labels[mark] = letterLabel(n)
+		case FootnoteNumberingContinuous:
+			labels[mark] = strconv.Itoa(n)
+		case FootnoteNumberingSymbols:
+			labels[mark] = symbolLabel(n)
+		default:
+			labels[mark] = sortOrderLabel(mark)
+		}
+	}
+
+	return labels
+}
+
+// NOTE: Returns marks with the labels of labels, marks without one are kept
+// as-is
+func labelMarks(marks []*biblev1.Mark, labels map[*biblev1.Mark]string) []*biblev1.Mark {
+	newMarks := make([]*biblev1.Mark, 0, len(marks))
+
+	for _, mark := range marks {
+		label, ok := labels[mark]
+		if !ok {
+			newMarks = append(newMarks, mark)
+
+			continue
+		}
+
+		newMark := cloneMark(mark)
+		newMark.Label = label
+
+		newMarks = append(newMarks, newMark)
+	}
+
+	return newMarks
+}
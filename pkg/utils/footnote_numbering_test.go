@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func TestLetterLabel(t *testing.T) {
+	tests := map[int]string{1: "a", 2: "b", 26: "z", 27: "aa", 28: "ab", 52: "az", 53: "ba", 702: "zz", 703: "aaa"}
+
+	for n, expected := range tests {
+		if result := letterLabel(n); result != expected {
+			t.Errorf("letterLabel(%d) = %q, want %q", n, result, expected)
+		}
+	}
+}
+
+func TestSymbolLabel(t *testing.T) {
+	tests := map[int]string{1: "*", 2: "†", 3: "‡", 6: "¶", 7: "**", 8: "††", 13: "***"}
+
+	for n, expected := range tests {
+		if result := symbolLabel(n); result != expected {
+			t.Errorf("symbolLabel(%d) = %q, want %q", n, result, expected)
+		}
+	}
+}
+
+func footnoteNumberingTestData() ([]*biblev1.Verse, []*biblev1.Mark) {
+	verses := []*biblev1.Verse{
+		{Id: "MAT.1.1", Number: 1, Label: "1", Text: "One two.", ChapterId: "MAT.1"},
+		{Id: "MAT.2.1", Number: 1, Label: "1", Text: "Three.", ParagraphNumber: 1, ChapterId: "MAT.2"},
+	}
+	marks := []*biblev1.Mark{
+		// NOTE: Out of order on purpose, marks are numbered by chapter then
+		// sort order
+		{Id: "fn3", Content: "Note 3", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, Label: "c", SortOrder: 0, StartOffset: 5, EndOffset: 5, TargetId: "MAT.2.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "MAT.2"},
+		{Id: "fn2", Content: "Note 2", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, Label: "b", SortOrder: 1, StartOffset: 7, EndOffset: 7, TargetId: "MAT.1.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "MAT.1"},
+		{Id: "fn1", Content: "Note 1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, Label: "a", SortOrder: 0, StartOffset: 3, EndOffset: 3, TargetId: "MAT.1.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "MAT.1"},
+		{Id: "ref1", Content: "Lk 3:23", Kind: biblev1.MarkKind_MARK_KIND_REFERENCE, SortOrder: 0, StartOffset: 7, EndOffset: 7, TargetId: "MAT.1.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "MAT.1"},
+	}
+
+	return verses, marks
+}
+
+func TestFootnoteNumbering(t *testing.T) {
+	verses, marks := footnoteNumberingTestData()
+
+	tests := []struct {
+		name      string
+		numbering FootnoteNumbering
+		expected  string
+	}{
+		{
+			name:      "sort order",
+			numbering: FootnoteNumberingSortOrder,
+			// NOTE: Notes keep the (Kind, SortOrder) order of the marks
+			expected: "One[1] two[2][1@].\n\nThree[1].\n\n[1] Note 3\n[1] Note 1\n[2] Note 2\n[1@] Lk 3:23",
+		},
+		{
+			name:      "mark label",
+			numbering: FootnoteNumberingMarkLabel,
+			expected:  "One[a] two[b][1@].\n\nThree[c].\n\n[a] Note 1\n[b] Note 2\n[c] Note 3\n[1@] Lk 3:23",
+		},
+		{
+			name:      "letters per chapter",
+			numbering: FootnoteNumberingLettersPerChapter,
+			expected:  "One[a] two[b][a@].\n\nThree[a].\n\n[a] Note 1\n[b] Note 2\n[a] Note 3\n[a@] Lk 3:23",
+		},
+		{
+			name:      "letters per passage",
+			numbering: FootnoteNumberingLettersPerPassage,
+			expected:  "One[a] two[b][a@].\n\nThree[c].\n\n[a] Note 1\n[b] Note 2\n[c] Note 3\n[a@] Lk 3:23",
+		},
+		{
+			name:      "continuous",
+			numbering: FootnoteNumberingContinuous,
+			expected:  "One[1] two[2][1@].\n\nThree[3].\n\n[1] Note 1\n[2] Note 2\n[3] Note 3\n[1@] Lk 3:23",
+		},
+		{
+			name:      "symbols",
+			numbering: FootnoteNumberingSymbols,
+			expected:  "One[*] two[†][*@].\n\nThree[*].\n\n[*] Note 1\n[†] Note 2\n[*] Note 3\n[*@] Lk 3:23",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ProcessVerseText(verses, marks, nil, nil, &RenderOptions{
+				ShowVerseNumbers:  boolPtr(false),
+				ShowChapterBreaks: boolPtr(false),
+				FootnoteNumbering: tt.numbering,
+			})
+			if err != nil {
+				t.Fatalf("ProcessVerseText() error = %v", err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("ProcessVerseText() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFootnoteNumbering_Html(t *testing.T) {
+	verses, marks := footnoteNumberingTestData()
+
+	result, err := ProcessVerseHtml(verses[1:], marks, nil, nil, &RenderOptions{
+		ShowVerseNumbers:  boolPtr(false),
+		ShowReferences:    boolPtr(false),
+		FootnoteNumbering: FootnoteNumberingSymbols,
+	})
+	if err != nil {
+		t.Fatalf("ProcessVerseHtml() error = %v", err)
+	}
+
+	// NOTE: Anchor ids do not depend on the labels
+	for _, expected := range []string{
+		`Three<sup><a href="#fn-1-MAT.2" id="fnref-1-MAT.2">*</a></sup>.`,
+		`<li id="fn-1-MAT.1"><p>Note 1 [<a href="#fnref-1-MAT.1">*</a>]</p></li>`,
+		`<li id="fn-2-MAT.1"><p>Note 2 [<a href="#fnref-2-MAT.1">†</a>]</p></li>`,
+		`<li id="fn-1-MAT.2"><p>Note 3 [<a href="#fnref-1-MAT.2">*</a>]</p></li>`,
+	} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("ProcessVerseHtml() = %q, want to contain %q", result, expected)
+		}
+	}
+}
+
+func TestFootnoteNumbering_Stream(t *testing.T) {
+	verses, marks := footnoteNumberingTestData()
+
+	var sb strings.Builder
+
+	// NOTE: Numbering continues across the chapters of the stream
+	err := StreamVerseText(&sb, slices.Values(verses), marks, nil, nil, &RenderOptions{
+		ShowVerseNumbers:  boolPtr(false),
+		ShowReferences:    boolPtr(false),
+		FootnoteNumbering: FootnoteNumberingContinuous,
+	})
+	if err != nil {
+		t.Fatalf("StreamVerseText() error = %v", err)
+	}
+
+	expected := "One[1] two[2].\n\n[1] Note 1\n[2] Note 2\n\nThree[3].\n\n[3] Note 3"
+
+	if sb.String() != expected {
+		t.Errorf("StreamVerseText() = %q, want %q", sb.String(), expected)
+	}
+}
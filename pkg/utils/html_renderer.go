@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// HtmlRenderer renders verses to HTML, text is converted from Markdown first.
+// Verse text, headings, psalm titles and footnotes are sanitised with Policy
+// unless Unsafe is set.
+type HtmlRenderer struct {
+	// NOTE: Keep raw HTML of the data as-is, only for trusted data
+	Unsafe bool
+	// NOTE: Allow-list of the safe mode, DefaultHtmlPolicy if nil
+	Policy *HtmlPolicy
+	// NOTE: Prefix of every generated id and href, e.g. to embed the same
+	// chapter twice in a page. RenderOptions.IdPrefix replaces it.
+	IdPrefix string
+}
+
+var (
+	_ Renderer      = (*HtmlRenderer)(nil)
+	_ VerseRenderer = (*HtmlRenderer)(nil)
+)
+
+var unspecifiedHtmlLabel = func(mark *biblev1.Mark, chapterId string) string {
+	return ""
+}
+
+func (r *HtmlRenderer) fnLabel(mark *biblev1.Mark, chapterId string) string {
+	return fmt.Sprintf(`<sup><a href="#%s" id="%s">%s</a></sup>`, r.id("fn-%d-%s", mark.SortOrder+1, chapterId), r.id("fnref-%d-%s", mark.SortOrder+1, chapterId), htmlEscaper.Replace(footnoteLabel(mark)))
+}
+
+func (r *HtmlRenderer) refLabel(mark *biblev1.Mark, chapterId string) string {
+	return fmt.Sprintf(`<sup><a href="#%s" id="%s">%s@</a></sup>`, r.id("fn-%d@-%s", mark.SortOrder+1, chapterId), r.id("fnref-%d@-%s", mark.SortOrder+1, chapterId), htmlEscaper.Replace(footnoteLabel(mark)))
+}
+
+var wojHtmlLabel = func(mark *biblev1.Mark, chapterId string) string {
+	return fmt.Sprintf("<b>%s</b>", mark.Content)
+}
+
+// NOTE: goldmark.Markdown is safe for concurrent use, so one converter is
+// shared by all renderers
+var mdConverter = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(
+		html.WithUnsafe(),
+	),
+)
+
+// NOTE: Output buffers of mdToHTML, reused between calls
+var mdBufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+var htmlParagraphRegex = regexp.MustCompile(`<p>|<\/p>\n?`)
+
+func mdToHTML(md string) string {
+	res := mdBufferPool.Get().(*bytes.Buffer)
+	defer mdBufferPool.Put(res)
+
+	res.Reset()
+
+	if err := mdConverter.Convert([]byte(md), res); err != nil {
+		return md
+	}
+
+	return res.String()
+}
+
+// NOTE: Converts Markdown text to HTML without the wrapping p element,
+// because it will create a new line
+func (r *HtmlRenderer) text(md string) string {
+	output := htmlParagraphRegex.ReplaceAllString(mdToHTML(md), "")
+
+	if r.Unsafe {
+		return output
+	}
+
+	return SanitizeHtml(output, r.Policy)
+}
+
+// NOTE: Returns an id with IdPrefix, escaped for an attribute value
+func (r *HtmlRenderer) id(format string, a ...any) string {
+	return htmlEscaper.Replace(r.IdPrefix + fmt.Sprintf(format, a...))
+}
+
+// NOTE: Escapes plain text values, e.g. verse labels, in the safe mode
+func (r *HtmlRenderer) escape(str string) string {
+	if r.Unsafe {
+		return str
+	}
+
+	return htmlEscaper.Replace(str)
+}
+
+func (r *HtmlRenderer) Inline(text string, marks []*biblev1.Mark, labelMap map[biblev1.MarkKind]MarkLabelFunc) string {
+	content, contentMarks := convertWithMarks(text, marks, r.text)
+
+	return InjectMarkLabel(content, contentMarks, labelMap)
+}
+
+func (r *HtmlRenderer) MarkLabels() map[biblev1.MarkKind]MarkLabelFunc {
+	return map[biblev1.MarkKind]MarkLabelFunc{
+		biblev1.MarkKind_MARK_KIND_UNSPECIFIED:    unspecifiedHtmlLabel,
+		biblev1.MarkKind_MARK_KIND_FOOTNOTE:       r.fnLabel,
+		biblev1.MarkKind_MARK_KIND_REFERENCE:      r.refLabel,
+		biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: wojHtmlLabel,
+	}
+}
+
+func (r *HtmlRenderer) VerseNumber(verse *biblev1.Verse) string {
+	return fmt.Sprintf("<sup><b>%s</b></sup>", r.escape(verse.Label))
+}
+
+// NOTE: Verses are wrapped in a span for deep links, e.g. "#v-JHN.3.16", and
+// client-side highlighting
+func (r *HtmlRenderer) Verse(verse *biblev1.Verse, content string) string {
+	return fmt.Sprintf(`<span id="%s" data-verse="%d" data-chapter="%s">%s</span>`, r.id("v-%s", verse.Id), verse.Number, htmlEscaper.Replace(verse.ChapterId), content)
+}
+
+func (r *HtmlRenderer) Poetry(content string) string {
+	return "\n<blockquote>" + content + "</blockquote>\n"
+}
+
+func (r *HtmlRenderer) PsalmTitle(psalm *biblev1.PsalmMetadata) string {
+	return fmt.Sprintf("<i>%s</i>", r.text(psalm.Text))
+}
+
+func (r *HtmlRenderer) Heading(heading *biblev1.Heading, content string) string {
+	// NOTE: Heading level starts from 1
+	return fmt.Sprintf("\n<h%d id=\"%s\">", heading.Level%MaxHeading, r.id("h-%s", heading.Id)) + content + fmt.Sprintf("</h%d>\n", heading.Level%MaxHeading)
+}
+
+func (r *HtmlRenderer) ChapterBreak() string {
+	return "\n\n<hr>\n\n"
+}
+
+func (r *HtmlRenderer) Footnote(mark *biblev1.Mark) string {
+	switch mark.Kind {
+	case biblev1.MarkKind_MARK_KIND_FOOTNOTE:
+		return fmt.Sprintf(`<li id="%s"><p>%s [<a href="#%s">%s</a>]</p></li>`, r.id("fn-%d-%s", mark.SortOrder+1, mark.ChapterId), r.text(mark.Content), r.id("fnref-%d-%s", mark.SortOrder+1, mark.ChapterId), htmlEscaper.Replace(footnoteLabel(mark)))
+	case biblev1.MarkKind_MARK_KIND_REFERENCE:
+		return fmt.Sprintf(`<li id="%s"><p>%s [<a href="#%s">%s@</a>]</p></li>`, r.id("fn-%d@-%s", mark.SortOrder+1, mark.ChapterId), r.text(mark.Content), r.id("fnref-%d@-%s", mark.SortOrder+1, mark.ChapterId), htmlEscaper.Replace(footnoteLabel(mark)))
+	default:
+		return ""
+	}
+}
+
+func (r *HtmlRenderer) InlineFootnote(mark *biblev1.Mark) string {
+	switch mark.Kind {
+	case biblev1.MarkKind_MARK_KIND_REFERENCE:
+		return fmt.Sprintf(`<span class="reference">%s</span>`, r.text(mark.Content))
+	default:
+		return fmt.Sprintf(`<span class="footnote">%s</span>`, r.text(mark.Content))
+	}
+}
+
+func (r *HtmlRenderer) FootnoteSection(footnotes []string) string {
+	return "<hr>\n\n<ol>" + joinFootnotes(footnotes) + "</ol>"
+}
+
+func (r *HtmlRenderer) Finalize(output string) string {
+	// NOTE: Should I clean up all "\n"?
+	output = strings.ReplaceAll(output, "\n</blockquote>", "</blockquote>")
+	// NOTE: Clean up the redundant newlines
+	output = redundantNewlinesRegex.ReplaceAllString(output, "\n\n")
+
+	return strings.TrimSpace(output)
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/samber/lo"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// NOTE: Top level elements of the RenderVerses output with HtmlRenderer, other
+// nodes are grouped in <p>
+var htmlBlockTags = []string{"aside", "blockquote", "div", "h1", "h2", "h3", "h4", "h5", "h6", "hr", "ol", "p", "section", "ul"}
+
+// NOTE: Parses the HTML of RenderVerses into its top level blocks. Loose inline
+// content, separated by blank lines, is wrapped in <p> elements.
+func htmlBlocks(fragment string) ([]*html.Node, error) {
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make([]*html.Node, 0)
+	paragraph := make([]*html.Node, 0)
+
+	flush := func() {
+		defer func() {
+			paragraph = paragraph[:0]
+		}()
+
+		// NOTE: Skip whitespace between block elements
+		if lo.EveryBy(paragraph, func(node *html.Node) bool {
+			return node.Type == html.TextNode && strings.TrimSpace(node.Data) == ""
+		}) {
+			return
+		}
+
+		block := &html.Node{Type: html.ElementNode, Data: "p", DataAtom: atom.P}
+
+		for _, node := range paragraph {
+			block.AppendChild(node)
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	for _, node := range nodes {
+		switch {
+		case node.Type == html.ElementNode && slices.Contains(htmlBlockTags, node.Data):
+			flush()
+
+			blocks = append(blocks, node)
+		case node.Type == html.TextNode:
+			for i, part := range strings.Split(node.Data, "\n\n") {
+				if i > 0 {
+					flush()
+				}
+
+				paragraph = append(paragraph, &html.Node{Type: html.TextNode, Data: part})
+			}
+		default:
+			paragraph = append(paragraph, node)
+		}
+	}
+
+	flush()
+
+	return blocks, nil
+}
+
+func htmlAttr(node *html.Node, name string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+	"golang.org/x/net/html"
+)
+
+// LatexPreamble defines the commands used by LatexRenderer. It goes before
+// \begin{document}, the commands can be redefined to change the layout, e.g.
+// \biblefootnote to a bibleref or reledmac apparatus.
+const LatexPreamble = `\usepackage{xcolor}
+\usepackage{lettrine}
+\usepackage{manyfoot}
+\DeclareNewFootnote{B}[alph]
+\newcommand{\chapternum}[1]{\lettrine[lines=2]{#1}{}}
+\newcommand{\versenum}[1]{\textsuperscript{\textbf{#1}}}
+\newcommand{\psalmtitle}[1]{\par{\itshape #1}\par}
+\newcommand{\wordsofjesus}[1]{\textcolor{red}{#1}}
+\newcommand{\biblefootnote}[1]{\footnote{#1}}
+\newcommand{\biblecrossref}[1]{\footnoteB{#1}}
+`
+
+// NOTE: Sectioning commands of the heading levels, starting from 1
+var latexSections = []string{"section", "subsection", "subsubsection", "paragraph", "subparagraph"}
+
+var latexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	"{", `\{`,
+	"}", `\}`,
+	"$", `\$`,
+	"&", `\&`,
+	"#", `\#`,
+	"^", `\textasciicircum{}`,
+	"_", `\_`,
+	"%", `\%`,
+	"~", `\textasciitilde{}`,
+	" ", "~",
+)
+
+// NOTE: LaTeX commands of the inline HTML elements, other elements keep their
+// text
+var latexInlineCommands = map[string]string{
+	"em":     `\emph{`,
+	"i":      `\emph{`,
+	"strong": `\textbf{`,
+	"b":      `\textbf{`,
+	"code":   `\texttt{`,
+	"sup":    `\textsuperscript{`,
+	"sub":    `\textsubscript{`,
+}
+
+// LatexRenderer renders verses to a LaTeX fragment for print typesetting, with
+// the commands of LatexPreamble: drop-cap chapter numbers, superscript verse
+// numbers, \biblefootnote for footnotes and a separate \biblecrossref
+// apparatus for references. Notes are always placed by LaTeX, so
+// FootnotePlacement only decides whether FootnoteFormatters are applied, with
+// FootnotePlacementInline.
+type LatexRenderer struct {
+	// NOTE: Chapters of the verses, the first verse of a chapter starts with
+	// its number as a drop cap. Verse numbers are used without them.
+	Chapters []*biblev1.Chapter
+}
+
+var _ Renderer = (*LatexRenderer)(nil)
+
+var unspecifiedLatexLabel = func(mark *biblev1.Mark, chapterId string) string {
+	return ""
+}
+
+var fnLatexLabel = func(mark *biblev1.Mark, chapterId string) string {
+	return fmt.Sprintf(`\biblefootnote{%s}`, mdToLatex(mark.Content))
+}
+
+var refLatexLabel = func(mark *biblev1.Mark, chapterId string) string {
+	return fmt.Sprintf(`\biblecrossref{%s}`, mdToLatex(mark.Content))
+}
+
+var wojLatexLabel = func(mark *biblev1.Mark, chapterId string) string {
+	return fmt.Sprintf(`\wordsofjesus{%s}`, mark.Content)
+}
+
+// NOTE: Converts Markdown text to LaTeX, through HTML so entities and inline
+// raw HTML are handled like in HtmlRenderer
+func mdToLatex(md string) string {
+	var sb strings.Builder
+
+	// NOTE: Closing braces of the open inline elements
+	closers := make([]string, 0)
+
+	walkHtml(mdToHTML(md), func(text string) {
+		sb.WriteString(latexEscaper.Replace(text))
+	}, func(token html.Token) {
+		switch {
+		// NOTE: goldmark writes hard line breaks as <br>, raw HTML may use <br/>
+		case token.Data == "br" && token.Type != html.EndTagToken:
+			sb.WriteString(`\newline `)
+		case token.Type == html.StartTagToken:
+			if command, ok := latexInlineCommands[token.Data]; ok {
+				sb.WriteString(command)
+				closers = append(closers, token.Data)
+			}
+		case token.Type == html.EndTagToken:
+			if len(closers) > 0 && closers[len(closers)-1] == token.Data {
+				sb.WriteString("}")
+				closers = closers[:len(closers)-1]
+			}
+		}
+	})
+
+	// NOTE: Close the elements left open by invalid HTML
+	sb.WriteString(strings.Repeat("}", len(closers)))
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (r *LatexRenderer) Inline(text string, marks []*biblev1.Mark, labelMap map[biblev1.MarkKind]MarkLabelFunc) string {
+	content, contentMarks := convertWithMarks(text, marks, mdToLatex)
+
+	return InjectMarkLabel(content, contentMarks, labelMap)
+}
+
+func (r *LatexRenderer) MarkLabels() map[biblev1.MarkKind]MarkLabelFunc {
+	return map[biblev1.MarkKind]MarkLabelFunc{
+		biblev1.MarkKind_MARK_KIND_UNSPECIFIED:    unspecifiedLatexLabel,
+		biblev1.MarkKind_MARK_KIND_FOOTNOTE:       fnLatexLabel,
+		biblev1.MarkKind_MARK_KIND_REFERENCE:      refLatexLabel,
+		biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: wojLatexLabel,
+	}
+}
+
+func (r *LatexRenderer) VerseNumber(verse *biblev1.Verse) string {
+	if verse.Number == 1 && verse.SubVerseIndex == 0 {
+		for _, chapter := range r.Chapters {
+			if chapter.Id == verse.ChapterId {
+				return fmt.Sprintf(`\chapternum{%d}`, chapter.Number)
+			}
+		}
+	}
+
+	return fmt.Sprintf(`\versenum{%s}`, latexEscaper.Replace(verse.Label))
+}
+
+// NOTE: Each poetry verse is one line of a verse environment, consecutive
+// environments in a paragraph are joined in Finalize
+func (r *LatexRenderer) Poetry(content string) string {
+	return "\n\\begin{verse}\n" + content + "\n\\end{verse}\n"
+}
+
+func (r *LatexRenderer) PsalmTitle(psalm *biblev1.PsalmMetadata) string {
+	return fmt.Sprintf(`\psalmtitle{%s}`, mdToLatex(psalm.Text))
+}
+
+func (r *LatexRenderer) Heading(heading *biblev1.Heading, content string) string {
+	// NOTE: Heading level starts from 1, deeper levels use \subparagraph
+	section := latexSections[min(max(int(heading.Level), 1), len(latexSections))-1]
+
+	return fmt.Sprintf("\n\\%s*{%s}\n", section, content)
+}
+
+func (r *LatexRenderer) ChapterBreak() string {
+	return "\n\n\\bigskip\n\n"
+}
+
+// NOTE: Notes are rendered in place of their labels, there is no footnote
+// section
+func (r *LatexRenderer) Footnote(mark *biblev1.Mark) string {
+	return ""
+}
+
+func (r *LatexRenderer) InlineFootnote(mark *biblev1.Mark) string {
+	switch mark.Kind {
+	case biblev1.MarkKind_MARK_KIND_REFERENCE:
+		return refLatexLabel(mark, mark.ChapterId)
+	default:
+		return fnLatexLabel(mark, mark.ChapterId)
+	}
+}
+
+func (r *LatexRenderer) FootnoteSection(footnotes []string) string {
+	return ""
+}
+
+func (r *LatexRenderer) Finalize(output string) string {
+	// NOTE: Poetry verses of the same paragraph are lines of one verse
+	// environment
+	output = strings.ReplaceAll(output, "\n\\end{verse}\n \n\\begin{verse}\n", " \\\\\n")
+
+	lines := strings.Split(output, "\n")
+
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+
+	output = strings.Join(lines, "\n")
+	// NOTE: Clean up the redundant newlines
+	output = redundantNewlinesRegex.ReplaceAllString(output, "\n\n")
+
+	return strings.TrimSpace(output)
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func TestLatexRenderer(t *testing.T) {
+	chapters := []*biblev1.Chapter{
+		{Id: "PSA.23", Number: 23},
+	}
+	verses := []*biblev1.Verse{
+		{Id: "PSA.23.1", Number: 1, Label: "1", Text: "The Lord is my *shepherd*;", IsPoetry: true, ChapterId: "PSA.23"},
+		{Id: "PSA.23.2", Number: 2, Label: "2", Text: "I shall not want.", IsPoetry: true, ChapterId: "PSA.23"},
+		{Id: "PSA.23.3", Number: 3, Label: "3", Text: "He restores my soul & 100% of me.", ParagraphNumber: 1, ChapterId: "PSA.23"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Content: "Or *keeper*", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 25, EndOffset: 25, TargetId: "PSA.23.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+		{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 14, TargetId: "PSA.23.3", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+		{Id: "ref1", Content: "Is 40:11", Kind: biblev1.MarkKind_MARK_KIND_REFERENCE, StartOffset: 17, EndOffset: 17, TargetId: "PSA.23.2", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+	}
+	headings := []*biblev1.Heading{
+		{Id: "h1", Text: "The **Good** Shepherd", Level: 1, VerseId: "PSA.23.1", ChapterId: "PSA.23"},
+		{Id: "h2", Text: "Restored", Level: 2, VerseId: "PSA.23.3", ChapterId: "PSA.23"},
+	}
+	psalms := []*biblev1.PsalmMetadata{
+		{Id: "p1", Text: "A Psalm of *David*.", ChapterId: "PSA.23"},
+	}
+
+	tests := []struct {
+		name     string
+		renderer *LatexRenderer
+		verses   []*biblev1.Verse
+		psalms   []*biblev1.PsalmMetadata
+		options  *RenderOptions
+		expected string
+	}{
+		{
+			name:     "default",
+			renderer: &LatexRenderer{Chapters: chapters},
+			verses:   verses,
+			expected: "\\section*{The \\textbf{Good} Shepherd}\n\n" +
+				"\\begin{verse}\n" +
+				"\\chapternum{23} The Lord is my \\emph{shepherd}\\biblefootnote{Or \\emph{keeper}}; \\\\\n" +
+				"\\versenum{2} I shall not want.\\biblecrossref{Is 40:11}\n" +
+				"\\end{verse}\n\n" +
+				"\\subsection*{Restored}\n" +
+				"\\versenum{3} \\wordsofjesus{He restores my} soul \\& 100\\% of me.",
+		},
+		{
+			name:     "without chapters",
+			renderer: &LatexRenderer{},
+			verses:   verses,
+			options:  &RenderOptions{ShowHeadings: boolPtr(false), ShowPsalmTitles: boolPtr(false)},
+			expected: "\\begin{verse}\n" +
+				"\\versenum{1} The Lord is my \\emph{shepherd}\\biblefootnote{Or \\emph{keeper}}; \\\\\n" +
+				"\\versenum{2} I shall not want.\\biblecrossref{Is 40:11}\n" +
+				"\\end{verse}\n\n" +
+				"\\versenum{3} \\wordsofjesus{He restores my} soul \\& 100\\% of me.",
+		},
+		{
+			name:     "inline footnotes with formatters",
+			renderer: &LatexRenderer{Chapters: chapters},
+			verses:   verses,
+			options: &RenderOptions{
+				ShowHeadings:      boolPtr(false),
+				ShowPsalmTitles:   boolPtr(false),
+				ShowVerseNumbers:  boolPtr(false),
+				FootnotePlacement: FootnotePlacementInline,
+				FootnoteFormatters: map[biblev1.MarkKind]FootnoteFormatFunc{
+					biblev1.MarkKind_MARK_KIND_REFERENCE: func(mark *biblev1.Mark) string {
+						return "See " + mark.Content
+					},
+				},
+			},
+			expected: "\\begin{verse}\n" +
+				"The Lord is my \\emph{shepherd}\\biblefootnote{Or \\emph{keeper}}; \\\\\n" +
+				"I shall not want.\\biblecrossref{See Is 40:11}\n" +
+				"\\end{verse}\n\n" +
+				"\\wordsofjesus{He restores my} soul \\& 100\\% of me.",
+		},
+		{
+			name:     "psalm title",
+			renderer: &LatexRenderer{Chapters: chapters},
+			verses:   verses[:1],
+			psalms:   psalms,
+			options:  &RenderOptions{ShowHeadings: boolPtr(false), ShowFootnotes: boolPtr(false)},
+			expected: "\\psalmtitle{A Psalm of \\emph{David}.}\n\n" +
+				"\\begin{verse}\n" +
+				"\\chapternum{23} The Lord is my \\emph{shepherd};\n" +
+				"\\end{verse}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := RenderVerses(tt.renderer, tt.verses, marks, headings, tt.psalms, tt.options)
+			if err != nil {
+				t.Fatalf("RenderVerses() error = %v", err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("RenderVerses() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMdToLatex(t *testing.T) {
+	tests := []struct {
+		name     string
+		md       string
+		expected string
+	}{
+		{
+			name:     "special characters",
+			md:       `a_b {c} $5 #1 ^ ~ \`,
+			expected: `a\_b \{c\} \$5 \#1 \textasciicircum{} \textasciitilde{} \textbackslash{}`,
+		},
+		{
+			name:     "no-break space",
+			md:       "Mt\u00A05",
+			expected: "Mt~5",
+		},
+		{
+			name:     "emphasis",
+			md:       "*a* **b** `c`",
+			expected: `\emph{a} \textbf{b} \texttt{c}`,
+		},
+		{
+			name:     "hard line break",
+			md:       "line one  \nline two",
+			expected: "line one\\newline \nline two",
+		},
+		{
+			name:     "raw line break",
+			md:       "line one<br/>line two",
+			expected: `line one\newline line two`,
+		},
+		{
+			name:     "dropped content",
+			md:       "a<script>b</script>c",
+			expected: "ac",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := mdToLatex(tt.md); result != tt.expected {
+				t.Errorf("mdToLatex() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
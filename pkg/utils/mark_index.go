@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"slices"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+// MarkIndex groups marks by target, so looking up the marks of one verse or
+// heading does not scan every mark of the passage.
+type MarkIndex struct {
+	targets map[markTarget][]*biblev1.Mark
+}
+
+type markTarget struct {
+	targetType biblev1.MarkTargetType
+	targetId   string
+}
+
+func NewMarkIndex(marks []*biblev1.Mark) *MarkIndex {
+	index := &MarkIndex{
+		targets: make(map[markTarget][]*biblev1.Mark),
+	}
+
+	for _, mark := range marks {
+		target := markTarget{targetType: mark.TargetType, targetId: mark.TargetId}
+
+		index.targets[target] = append(index.targets[target], mark)
+	}
+
+	return index
+}
+
+// Target returns the marks of a verse or heading in input order. If kinds is
+// not empty, only marks of these kinds are returned.
+func (idx *MarkIndex) Target(targetType biblev1.MarkTargetType, targetId string, kinds ...biblev1.MarkKind) []*biblev1.Mark {
+	marks := idx.targets[markTarget{targetType: targetType, targetId: targetId}]
+
+	result := make([]*biblev1.Mark, 0, len(marks))
+
+	for _, mark := range marks {
+		if len(kinds) == 0 || slices.Contains(kinds, mark.Kind) {
+			result = append(result, mark)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func markIds(marks []*biblev1.Mark) []string {
+	ids := make([]string, 0, len(marks))
+
+	for _, mark := range marks {
+		ids = append(ids, mark.Id)
+	}
+
+	return ids
+}
+
+func TestMarkIndex_Target(t *testing.T) {
+	marks := []*biblev1.Mark{
+		{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 10, TargetId: "GEN.1.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE},
+		{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 5, EndOffset: 5, TargetId: "GEN.1.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE},
+		{Id: "fn2", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 2, EndOffset: 2, TargetId: "GEN.1.2", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE},
+		{Id: "ref1", Kind: biblev1.MarkKind_MARK_KIND_REFERENCE, StartOffset: 3, EndOffset: 3, TargetId: "GEN.1.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE},
+		// NOTE: Same target id but a heading
+		{Id: "fn3", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 0, EndOffset: 0, TargetId: "GEN.1.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_HEADING},
+	}
+
+	index := NewMarkIndex(marks)
+
+	tests := []struct {
+		name       string
+		targetType biblev1.MarkTargetType
+		targetId   string
+		kinds      []biblev1.MarkKind
+		expected   []string
+	}{
+		{
+			name:       "all marks of a verse in input order",
+			targetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE,
+			targetId:   "GEN.1.1",
+			expected:   []string{"woj1", "fn1", "ref1"},
+		},
+		{
+			name:       "filtered by kind",
+			targetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE,
+			targetId:   "GEN.1.1",
+			kinds:      []biblev1.MarkKind{biblev1.MarkKind_MARK_KIND_FOOTNOTE, biblev1.MarkKind_MARK_KIND_REFERENCE},
+			expected:   []string{"fn1", "ref1"},
+		},
+		{
+			name:       "heading target",
+			targetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_HEADING,
+			targetId:   "GEN.1.1",
+			expected:   []string{"fn3"},
+		},
+		{
+			name:       "unknown target",
+			targetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE,
+			targetId:   "GEN.1.3",
+			expected:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := markIds(index.Target(tt.targetType, tt.targetId, tt.kinds...))
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("MarkIndex.Target() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func BenchmarkMarkIndex_Target(b *testing.B) {
+	verses, marks, _, _ := benchmarkBook()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index := NewMarkIndex(marks)
+
+		for _, verse := range verses {
+			index.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, verse.Id, biblev1.MarkKind_MARK_KIND_FOOTNOTE)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+// MdRenderer renders verses to Markdown with GFM footnotes.
+type MdRenderer struct {
+	// NOTE: Prefix of the footnote keys, e.g. to embed the same chapter twice
+	// in a page. RenderOptions.IdPrefix replaces it.
+	IdPrefix string
+}
+
+var _ Renderer = (*MdRenderer)(nil)
+
+var (
+	mdBlockquoteNewlinesRegex = regexp.MustCompile(`(?m)^>\n+>`)
+	mdBlockquoteEndRegex      = regexp.MustCompile(`(?m)^>\n\n`)
+	// NOTE: Characters ending or breaking a footnote label
+	mdFootnoteKeyRegex = regexp.MustCompile(`[\s\[\]^\\]`)
+)
+
+var unspecifiedMdLabel = func(mark *biblev1.Mark, chapterId string) string {
+	return ""
+}
+
+// NOTE: Returns a footnote key with IdPrefix, characters not allowed in a
+// footnote label are replaced with "-"
+func (r *MdRenderer) key(format string, a ...any) string {
+	return mdFootnoteKeyRegex.ReplaceAllString(r.IdPrefix+fmt.Sprintf(format, a...), "-")
+}
+
+func (r *MdRenderer) fnLabel(mark *biblev1.Mark, chapterId string) string {
+	return fmt.Sprintf("[^%s]", r.key("%d-%s", mark.SortOrder+1, chapterId))
+}
+
+func (r *MdRenderer) refLabel(mark *biblev1.Mark, chapterId string) string {
+	return fmt.Sprintf("[^%s]", r.key("%d@-%s", mark.SortOrder+1, chapterId))
+}
+
+var wojMdLabel = func(mark *biblev1.Mark, chapterId string) string {
+	return fmt.Sprintf("<b>%s</b>", mark.Content)
+}
+
+func (r *MdRenderer) Inline(text string, marks []*biblev1.Mark, labelMap map[biblev1.MarkKind]MarkLabelFunc) string {
+	return InjectMarkLabel(text, marks, labelMap)
+}
+
+func (r *MdRenderer) MarkLabels() map[biblev1.MarkKind]MarkLabelFunc {
+	return map[biblev1.MarkKind]MarkLabelFunc{
+		biblev1.MarkKind_MARK_KIND_UNSPECIFIED:    unspecifiedMdLabel,
+		biblev1.MarkKind_MARK_KIND_FOOTNOTE:       r.fnLabel,
+		biblev1.MarkKind_MARK_KIND_REFERENCE:      r.refLabel,
+		biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: wojMdLabel,
+	}
+}
+
+func (r *MdRenderer) VerseNumber(verse *biblev1.Verse) string {
+	return fmt.Sprintf("<sup><b>%s</b></sup>", verse.Label)
+}
+
+func (r *MdRenderer) Poetry(content string) string {
+	return "\n> " + content + "\n>"
+}
+
+func (r *MdRenderer) PsalmTitle(psalm *biblev1.PsalmMetadata) string {
+	return fmt.Sprintf("*%s*", psalm.Text)
+}
+
+func (r *MdRenderer) Heading(heading *biblev1.Heading, content string) string {
+	// NOTE: Heading level starts from 1
+	return fmt.Sprintf("\n%s ", strings.Repeat("#", int(heading.Level)%MaxHeading)) + content + "\n"
+}
+
+func (r *MdRenderer) ChapterBreak() string {
+	return "\n\n---\n\n"
+}
+
+func (r *MdRenderer) Footnote(mark *biblev1.Mark) string {
+	switch mark.Kind {
+	case biblev1.MarkKind_MARK_KIND_FOOTNOTE:
+		return fmt.Sprintf("[^%s]: %s", r.key("%d-%s", mark.SortOrder+1, mark.ChapterId), mark.Content)
+	case biblev1.MarkKind_MARK_KIND_REFERENCE:
+		return fmt.Sprintf("[^%s]: %s", r.key("%d@-%s", mark.SortOrder+1, mark.ChapterId), mark.Content)
+	default:
+		return ""
+	}
+}
+
+// NOTE: Uses the inline footnote syntax of Pandoc and markdown-it, GFM
+// renders it as plain text
+func (r *MdRenderer) InlineFootnote(mark *biblev1.Mark) string {
+	return fmt.Sprintf("^[%s]", mark.Content)
+}
+
+func (r *MdRenderer) FootnoteSection(footnotes []string) string {
+	return "\n\n" + joinFootnotes(footnotes)
+}
+
+func (r *MdRenderer) Finalize(output string) string {
+	// NOTE: Clean up the blockquote redundant characters. Note to cleanup the
+	// blockquote characters, we need to replace the `>` characters at the
+	// beginning of the line
+	output = mdBlockquoteNewlinesRegex.ReplaceAllString(output, ">\n>")
+	output = mdBlockquoteEndRegex.ReplaceAllString(output, ">\n>")
+	// NOTE: Clean up the redundant newlines
+	output = redundantNewlinesRegex.ReplaceAllString(output, "\n\n")
+
+	return strings.TrimSpace(output)
+}
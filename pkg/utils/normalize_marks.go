@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+	"golang.org/x/exp/utf8string"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeMarks converts text to the given Unicode normalization form (e.g.
+// norm.NFC or norm.NFD) and returns copies of marks with their rune offsets
+// shifted to match the normalized text.
+//
+// NOTE: An offset pointing inside a normalization segment (e.g. between a base
+// letter and its combining tone mark in NFD) is widened to the segment
+// boundary: start offsets move backward, end offsets move forward.
+func NormalizeMarks(text string, marks []*biblev1.Mark, form norm.Form) (string, []*biblev1.Mark) {
+	normalized, oldBoundaries, newBoundaries := normalizeWithBoundaries(text, form)
+
+	newMarks := make([]*biblev1.Mark, 0, len(marks))
+
+	for _, mark := range marks {
+		newMark := cloneMark(mark)
+
+		startOffset := mapNormalizedOffset(int(mark.StartOffset), oldBoundaries, newBoundaries, false)
+		endOffset := mapNormalizedOffset(int(mark.EndOffset), oldBoundaries, newBoundaries, true)
+
+		// NOTE: Zero-width marks must stay zero-width
+		if mark.StartOffset == mark.EndOffset {
+			startOffset = endOffset
+		}
+
+		newMark.StartOffset = int32(startOffset)
+		newMark.EndOffset = int32(endOffset)
+		newMark.Content = form.String(mark.Content)
+
+		newMarks = append(newMarks, newMark)
+	}
+
+	return normalized, newMarks
+}
+
+// NormalizeVerse returns a copy of verse with its text converted to the given
+// normalization form. Marks targeting the verse are shifted to match, other
+// marks are returned unchanged.
+func NormalizeVerse(verse *biblev1.Verse, marks []*biblev1.Mark, form norm.Form) (*biblev1.Verse, []*biblev1.Mark) {
+	verseMarks := make([]*biblev1.Mark, 0)
+	otherMarks := make([]*biblev1.Mark, 0)
+
+	for _, mark := range marks {
+		if mark.TargetType == biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE && mark.TargetId == verse.Id {
+			verseMarks = append(verseMarks, mark)
+		} else {
+			otherMarks = append(otherMarks, mark)
+		}
+	}
+
+	newText, newVerseMarks := NormalizeMarks(verse.Text, verseMarks, form)
+
+	newVerse := cloneVerse(verse)
+	newVerse.Text = newText
+
+	return newVerse, append(newVerseMarks, otherMarks...)
+}
+
+// NOTE: Returns the normalized string with the rune offsets of every
+// normalization segment boundary in both the original and normalized strings.
+// Both boundary slices have the same length and start with 0.
+func normalizeWithBoundaries(text string, form norm.Form) (string, []int, []int) {
+	oldBoundaries := []int{0}
+	newBoundaries := []int{0}
+
+	var (
+		iter       norm.Iter
+		normalized []byte
+	)
+
+	iter.InitString(form, text)
+
+	oldRunes := 0
+	newRunes := 0
+	prevPos := 0
+
+	for !iter.Done() {
+		segment := iter.Next()
+		pos := iter.Pos()
+
+		oldRunes += utf8.RuneCountInString(text[prevPos:pos])
+		newRunes += utf8.RuneCount(segment)
+		prevPos = pos
+
+		normalized = append(normalized, segment...)
+		oldBoundaries = append(oldBoundaries, oldRunes)
+		newBoundaries = append(newBoundaries, newRunes)
+	}
+
+	return string(normalized), oldBoundaries, newBoundaries
+}
+
+func mapNormalizedOffset(offset int, oldBoundaries, newBoundaries []int, roundUp bool) int {
+	if offset <= 0 {
+		return 0
+	}
+
+	last := len(oldBoundaries) - 1
+
+	if offset >= oldBoundaries[last] {
+		return newBoundaries[last] + offset - oldBoundaries[last]
+	}
+
+	idx := sort.SearchInts(oldBoundaries, offset)
+
+	if oldBoundaries[idx] == offset || roundUp {
+		return newBoundaries[idx]
+	}
+
+	return newBoundaries[idx-1]
+}
+
+// NOTE: Returns the rune offsets of every grapheme cluster boundary in str,
+// including 0 and the rune count of str.
+func graphemeBoundaries(str string) []int {
+	// NOTE: Every ASCII character is a cluster of its own, except CR LF
+	if isASCII(str) && !strings.Contains(str, "\r\n") {
+		boundaries := make([]int, len(str)+1)
+
+		for i := range boundaries {
+			boundaries[i] = i
+		}
+
+		return boundaries
+	}
+
+	boundaries := []int{0}
+	runeCount := 0
+
+	// NOTE: FirstGraphemeClusterInString only computes grapheme breaks,
+	// Graphemes also tracks word, sentence and line breaks
+	state := -1
+
+	for str != "" {
+		var cluster string
+
+		cluster, str, _, state = uniseg.FirstGraphemeClusterInString(str, state)
+		runeCount += utf8.RuneCountInString(cluster)
+		boundaries = append(boundaries, runeCount)
+	}
+
+	return boundaries
+}
+
+func isASCII(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if str[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NOTE: Moves offset to the closest grapheme cluster boundary, backward if
+// roundUp is false and forward otherwise.
+func snapToGrapheme(offset int, boundaries []int, roundUp bool) int {
+	idx := sort.SearchInts(boundaries, offset)
+
+	if idx == len(boundaries) {
+		return boundaries[len(boundaries)-1]
+	}
+
+	if boundaries[idx] == offset || roundUp {
+		return boundaries[idx]
+	}
+
+	return boundaries[idx-1]
+}
+
+// NOTE: Returns copies of marks with offsets that never cut through a
+// grapheme cluster of str, so a label is never spliced between a base letter
+// and its combining marks.
+func snapMarksToGraphemes(str string, marks []*biblev1.Mark) []*biblev1.Mark {
+	if len(marks) == 0 {
+		return make([]*biblev1.Mark, 0)
+	}
+
+	boundaries := graphemeBoundaries(str)
+	runeCount := utf8string.NewString(str).RuneCount()
+
+	newMarks := make([]*biblev1.Mark, 0, len(marks))
+
+	for _, mark := range marks {
+		newMark := cloneMark(mark)
+
+		startOffset := min(int(mark.StartOffset), runeCount)
+		endOffset := min(int(mark.EndOffset), runeCount)
+
+		if startOffset == endOffset {
+			// NOTE: Zero-width marks are moved after the cluster they point into
+			startOffset = snapToGrapheme(startOffset, boundaries, true)
+			endOffset = startOffset
+		} else {
+			startOffset = snapToGrapheme(startOffset, boundaries, false)
+			endOffset = snapToGrapheme(endOffset, boundaries, true)
+		}
+
+		newMark.StartOffset = int32(startOffset)
+		newMark.EndOffset = int32(endOffset)
+
+		newMarks = append(newMarks, newMark)
+	}
+
+	return newMarks
+}
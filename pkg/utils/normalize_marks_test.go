@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+	"golang.org/x/exp/utf8string"
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestNormalizeMarks(t *testing.T) {
+	// NOTE: The same text has more runes in NFD than in NFC
+	nfcText := norm.NFC.String("Lúc khởi đầu đã có Ngôi Lời, và Ngôi Lời vẫn hướng về Thiên Chúa")
+	nfdText := norm.NFD.String(nfcText)
+
+	tests := []struct {
+		name          string
+		input         string
+		form          norm.Form
+		marks         []*biblev1.Mark
+		expectedText  string
+		expectedSpans []string
+	}{
+		{
+			name:  "NFC to NFD",
+			input: nfcText,
+			form:  norm.NFD,
+			marks: []*biblev1.Mark{
+				{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 19, EndOffset: 27},
+				{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 64, EndOffset: 64},
+			},
+			expectedText:  nfdText,
+			expectedSpans: []string{norm.NFD.String("Ngôi Lời"), ""},
+		},
+		{
+			name:  "NFD to NFC",
+			input: nfdText,
+			form:  norm.NFC,
+			marks: []*biblev1.Mark{
+				{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 26, EndOffset: 37},
+			},
+			expectedText:  nfcText,
+			expectedSpans: []string{"Ngôi Lời"},
+		},
+		{
+			name:  "offset inside a combining sequence is widened",
+			input: norm.NFD.String("Lời"),
+			form:  norm.NFC,
+			marks: []*biblev1.Mark{
+				// NOTE: "ờ" is "o" + U+031B + U+0300 in NFD, 2 points inside it
+				{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 2, EndOffset: 3},
+			},
+			expectedText:  "Lời",
+			expectedSpans: []string{"ờ"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, marks := NormalizeMarks(tt.input, tt.marks, tt.form)
+			if text != tt.expectedText {
+				t.Errorf("NormalizeMarks() text = %q, want %q", text, tt.expectedText)
+			}
+
+			if len(marks) != len(tt.marks) {
+				t.Fatalf("NormalizeMarks() returned %d marks, want %d", len(marks), len(tt.marks))
+			}
+
+			str := utf8string.NewString(text)
+
+			for i, mark := range marks {
+				span := str.Slice(int(mark.StartOffset), int(mark.EndOffset))
+				if span != tt.expectedSpans[i] {
+					t.Errorf("NormalizeMarks() mark %s spans %q, want %q", mark.Id, span, tt.expectedSpans[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeMarks_DoesNotMutateInput(t *testing.T) {
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 3, EndOffset: 3},
+	}
+
+	NormalizeMarks(norm.NFC.String("Lời Chúa"), marks, norm.NFD)
+
+	if marks[0].StartOffset != 3 || marks[0].EndOffset != 3 {
+		t.Errorf("NormalizeMarks() mutated input mark: %+v", marks[0])
+	}
+}
+
+func TestNormalizeVerse(t *testing.T) {
+	verse := &biblev1.Verse{
+		Id:        "JHN.1.1",
+		Text:      norm.NFD.String("Lúc khởi đầu đã có Ngôi Lời"),
+		Label:     "1",
+		ChapterId: "JHN.1",
+	}
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 37, EndOffset: 37, TargetId: "JHN.1.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE},
+		{Id: "fn2", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 33, EndOffset: 33, TargetId: "JHN.1.2", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE},
+	}
+
+	newVerse, newMarks := NormalizeVerse(verse, marks, norm.NFC)
+
+	if newVerse.Text != "Lúc khởi đầu đã có Ngôi Lời" {
+		t.Errorf("NormalizeVerse() text = %q", newVerse.Text)
+	}
+
+	if verse.Text == newVerse.Text {
+		t.Errorf("NormalizeVerse() mutated input verse")
+	}
+
+	if newMarks[0].StartOffset != 27 {
+		t.Errorf("NormalizeVerse() verse mark offset = %d, want 27", newMarks[0].StartOffset)
+	}
+
+	if newMarks[1].StartOffset != 33 {
+		t.Errorf("NormalizeVerse() other verse mark offset = %d, want 33", newMarks[1].StartOffset)
+	}
+}
+
+func TestInjectMarkLabel_GraphemeClusters(t *testing.T) {
+	labelMap := map[biblev1.MarkKind]func(mark *biblev1.Mark, chapterId string) string{
+		biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark, chapterId string) string {
+			return fmt.Sprintf("<sup>%s</sup>", mark.Label)
+		},
+		biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: func(mark *biblev1.Mark, chapterId string) string {
+			return fmt.Sprintf("<b>%s</b>", mark.Content)
+		},
+	}
+
+	// NOTE: "Lời" in NFD is "L", "o", U+031B, U+0300, "i"
+	input := norm.NFD.String("Lời Chúa")
+
+	tests := []struct {
+		name     string
+		marks    []*biblev1.Mark
+		expected string
+	}{
+		{
+			name: "zero-width mark inside a cluster is moved after it",
+			marks: []*biblev1.Mark{
+				{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, Label: "a", StartOffset: 3, EndOffset: 3},
+			},
+			expected: norm.NFD.String("Lờ") + "<sup>a</sup>" + norm.NFD.String("i Chúa"),
+		},
+		{
+			name: "span cutting through a cluster is widened",
+			marks: []*biblev1.Mark{
+				{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, Content: norm.NFD.String("ời"), StartOffset: 2, EndOffset: 5},
+			},
+			expected: "L<b>" + norm.NFD.String("ời") + "</b>" + norm.NFD.String(" Chúa"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := InjectMarkLabel(input, tt.marks, labelMap)
+			if result != tt.expected {
+				t.Errorf("InjectMarkLabel() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
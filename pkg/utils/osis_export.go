@@ -0,0 +1,262 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/samber/lo"
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+var (
+	ErrOsisChapterNotFound = errors.New("osis chapter not found")
+	ErrOsisBookNotFound    = errors.New("osis book not found")
+	ErrOsisWriterClosed    = errors.New("osis writer closed")
+)
+
+const osisNamespace = "http://www.bibletechnologies.net/2003/OSIS/namespace"
+
+var osisMarkLabels = map[biblev1.MarkKind]MarkLabelFunc{
+	biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`<note>%s</note>`, osisText(mark.Content))
+	},
+	biblev1.MarkKind_MARK_KIND_REFERENCE: func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`<note type="crossReference">%s</note>`, osisText(mark.Content))
+	},
+	biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`<q who="Jesus" marker="">%s</q>`, mark.Content)
+	},
+}
+
+// NOTE: Converts Markdown text to escaped XML text
+func osisText(md string) string {
+	return htmlEscaper.Replace(mdToText(md))
+}
+
+// OsisWriter writes books to an OSIS XML document one at a time. Verses are
+// written as milestones, poetry as <lg> and <l>, footnotes and references as
+// <note> and words of Jesus as <q who="Jesus">. Close must be called to end
+// the document.
+type OsisWriter struct {
+	xmlWriter
+	// NOTE: osisIDWork of the document
+	work    string
+	started bool
+	closed  bool
+}
+
+// NewOsisWriter creates an OsisWriter writing to w. work is the osisIDWork of
+// the document, "Bible" if empty.
+func NewOsisWriter(w io.Writer, work string) *OsisWriter {
+	if work == "" {
+		work = "Bible"
+	}
+
+	return &OsisWriter{
+		xmlWriter: xmlWriter{w: w},
+		work:      work,
+	}
+}
+
+// ExportOsis writes a book as an OSIS XML document to w.
+func ExportOsis(w io.Writer, book *biblev1.Book, chapters []*biblev1.Chapter, verses []*biblev1.Verse, marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata) error {
+	osisWriter := NewOsisWriter(w, "")
+
+	if err := osisWriter.WriteBook(book, chapters, verses, marks, headings, psalms); err != nil {
+		return err
+	}
+
+	return osisWriter.Close()
+}
+
+func (w *OsisWriter) start() {
+	if w.started {
+		return
+	}
+
+	w.started = true
+
+	work := htmlEscaper.Replace(w.work)
+
+	w.write(
+		`<?xml version="1.0" encoding="UTF-8"?>`+"\n",
+		fmt.Sprintf(`<osis xmlns="%s">`, osisNamespace)+"\n",
+		fmt.Sprintf(`<osisText osisIDWork="%s" osisRefWork="Bible">`, work)+"\n",
+		fmt.Sprintf(`<header><work osisWork="%s"/></header>`, work)+"\n",
+	)
+}
+
+// WriteBook writes a book to the document. chapters provides the chapter
+// numbers, verses must be in reading order.
+func (w *OsisWriter) WriteBook(book *biblev1.Book, chapters []*biblev1.Chapter, verses []*biblev1.Verse, marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata) error {
+	if w.closed {
+		return ErrOsisWriterClosed
+	}
+
+	if book == nil {
+		return ErrOsisBookNotFound
+	}
+
+	chapterNumbers := make(map[string]int32, len(chapters))
+
+	for _, chapter := range chapters {
+		chapterNumbers[chapter.Id] = chapter.Number
+	}
+
+	// NOTE: Checked before writing, so a failed book is not half written
+	for _, verse := range verses {
+		if _, ok := chapterNumbers[verse.ChapterId]; !ok {
+			return fmt.Errorf("%w: %s", ErrOsisChapterNotFound, verse.ChapterId)
+		}
+	}
+
+	markIndex := NewMarkIndex(marks)
+	headingsByVerse := lo.GroupBy(headings, func(h *biblev1.Heading) string {
+		return h.VerseId
+	})
+	psalmsByChapter := lo.GroupBy(psalms, func(p *biblev1.PsalmMetadata) string {
+		return p.ChapterId
+	})
+
+	w.start()
+
+	code := htmlEscaper.Replace(book.Code)
+
+	w.write(fmt.Sprintf(`<div type="book" osisID="%s">`, code), "\n")
+
+	if book.Name != "" {
+		w.write(fmt.Sprintf(`<title type="main">%s</title>`, osisText(book.Name)), "\n")
+	}
+
+	currentChapterId := ""
+	chapterPrefix := ""
+	currPar := int32(-1)
+	paragraphOpen := false
+	poetryOpen := false
+
+	closeParagraph := func() {
+		if poetryOpen {
+			w.write("</lg>\n")
+		}
+
+		if paragraphOpen {
+			w.write("</p>\n")
+		}
+
+		paragraphOpen = false
+		poetryOpen = false
+	}
+
+	for i, verse := range verses {
+		newChapter := verse.ChapterId != currentChapterId
+
+		if newChapter {
+			closeParagraph()
+
+			if currentChapterId != "" {
+				w.write("</chapter>\n")
+			}
+
+			chapterPrefix = fmt.Sprintf("%s.%d", code, chapterNumbers[verse.ChapterId])
+
+			w.write(fmt.Sprintf(`<chapter osisID="%s">`, chapterPrefix), "\n")
+
+			currentChapterId = verse.ChapterId
+			currPar = -1
+		}
+
+		verseHeadings := headingsByVerse[verse.Id]
+		chapterPsalms := lo.Ternary(newChapter, psalmsByChapter[verse.ChapterId], nil)
+
+		// NOTE: Titles are not allowed inside paragraphs
+		if len(verseHeadings) > 0 || len(chapterPsalms) > 0 {
+			closeParagraph()
+		}
+
+		for _, heading := range verseHeadings {
+			headingMarks := markIndex.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_HEADING, heading.Id, biblev1.MarkKind_MARK_KIND_FOOTNOTE, biblev1.MarkKind_MARK_KIND_REFERENCE)
+
+			w.write(fmt.Sprintf(`<title level="%d">%s</title>`, max(heading.Level, 1), exportInline(heading.Text, headingMarks, osisText, osisMarkLabels)), "\n")
+		}
+
+		for _, psalm := range chapterPsalms {
+			w.write(fmt.Sprintf(`<title type="psalm" canonical="true">%s</title>`, osisText(psalm.Text)), "\n")
+		}
+
+		newParagraph := verse.ParagraphNumber != currPar
+
+		if newParagraph || !paragraphOpen {
+			closeParagraph()
+
+			// NOTE: A paragraph broken by a title continues after it
+			w.write(lo.Ternary(newParagraph, "<p>\n", `<p type="x-continued">`+"\n"))
+
+			paragraphOpen = true
+		}
+
+		switch {
+		case verse.IsPoetry && !poetryOpen:
+			w.write("<lg>\n")
+
+			poetryOpen = true
+		case !verse.IsPoetry && poetryOpen:
+			// NOTE: Prose continuing the paragraph after poetry
+			w.write("</lg>\n")
+
+			poetryOpen = false
+		}
+
+		verseMarks := markIndex.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, verse.Id, biblev1.MarkKind_MARK_KIND_FOOTNOTE, biblev1.MarkKind_MARK_KIND_REFERENCE, biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS)
+
+		var sb strings.Builder
+
+		osisId := fmt.Sprintf("%s.%d", chapterPrefix, verse.Number)
+
+		// NOTE: Sub-verses continue the verse of the previous milestone
+		if verse.SubVerseIndex == 0 {
+			sb.WriteString(fmt.Sprintf(`<verse osisID="%s" sID="%s"/>`, osisId, osisId))
+		}
+
+		sb.WriteString(exportInline(verse.Text, verseMarks, osisText, osisMarkLabels))
+
+		continued := i+1 < len(verses) && verses[i+1].SubVerseIndex > 0 && verses[i+1].ChapterId == verse.ChapterId && verses[i+1].Number == verse.Number
+		if !continued {
+			sb.WriteString(fmt.Sprintf(`<verse eID="%s"/>`, osisId))
+		}
+
+		if verse.IsPoetry {
+			// NOTE: Each poetry verse is one line of poetry
+			w.write("<l>", sb.String(), "</l>\n")
+		} else {
+			w.write(sb.String(), "\n")
+		}
+
+		currPar = verse.ParagraphNumber
+	}
+
+	closeParagraph()
+
+	if currentChapterId != "" {
+		w.write("</chapter>\n")
+	}
+
+	w.write("</div>\n")
+
+	return w.err
+}
+
+// Close ends the document. It does not close the underlying io.Writer.
+func (w *OsisWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+
+	w.start()
+	w.write("</osisText>\n</osis>\n")
+
+	w.closed = true
+
+	return w.err
+}
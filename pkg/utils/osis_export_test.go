@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExportOsis(t *testing.T) {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<osis xmlns="http://www.bibletechnologies.net/2003/OSIS/namespace">
+<osisText osisIDWork="Bible" osisRefWork="Bible">
+<header><work osisWork="Bible"/></header>
+<div type="book" osisID="psa">
+<title type="main">Psalms</title>
+<chapter osisID="psa.23">
+<title level="1">The Good<note>Or life</note> Shepherd</title>
+<title type="psalm" canonical="true">A Psalm of David.</title>
+<p>
+<lg>
+<l><verse osisID="psa.23.1" sID="psa.23.1"/>The Lord is my shepherd<note>Or keeper</note>;</l>
+<l>I shall not want.<verse eID="psa.23.1"/></l>
+</lg>
+<verse osisID="psa.23.2" sID="psa.23.2"/>He said, <q who="Jesus" marker="">Follow<note type="crossReference">Mt 4:19</note> me</q>.<verse eID="psa.23.2"/>
+</p>
+<p>
+<verse osisID="psa.23.3" sID="psa.23.3"/>He restores my soul.<verse eID="psa.23.3"/>
+</p>
+</chapter>
+<chapter osisID="psa.24">
+<title level="5">The King of Glory</title>
+<p>
+<verse osisID="psa.24.1" sID="psa.24.1"/>The earth is the Lord's.<verse eID="psa.24.1"/>
+</p>
+</chapter>
+</div>
+</osisText>
+</osis>
+`
+
+	var sb strings.Builder
+
+	if err := ExportOsis(&sb, book, chapters, verses, marks, headings, psalms); err != nil {
+		t.Fatalf("ExportOsis() error = %v", err)
+	}
+
+	if sb.String() != expected {
+		t.Errorf("ExportOsis() = %q, want %q", sb.String(), expected)
+	}
+}
+
+func TestOsisWriter(t *testing.T) {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+
+	var sb strings.Builder
+
+	osisWriter := NewOsisWriter(&sb, "KJV & co")
+
+	if err := osisWriter.WriteBook(book, chapters, verses[:1], nil, nil, nil); err != nil {
+		t.Fatalf("WriteBook() error = %v", err)
+	}
+
+	if err := osisWriter.WriteBook(book, chapters[:1], verses, marks, headings, psalms); !errors.Is(err, ErrOsisChapterNotFound) {
+		t.Errorf("WriteBook() error = %v, want %v", err, ErrOsisChapterNotFound)
+	}
+
+	if err := osisWriter.WriteBook(nil, chapters, verses, marks, headings, psalms); !errors.Is(err, ErrOsisBookNotFound) {
+		t.Errorf("WriteBook() error = %v, want %v", err, ErrOsisBookNotFound)
+	}
+
+	if err := osisWriter.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if err := osisWriter.WriteBook(book, chapters, verses, marks, headings, psalms); !errors.Is(err, ErrOsisWriterClosed) {
+		t.Errorf("WriteBook() error = %v, want %v", err, ErrOsisWriterClosed)
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<osis xmlns="http://www.bibletechnologies.net/2003/OSIS/namespace">
+<osisText osisIDWork="KJV &amp; co" osisRefWork="Bible">
+<header><work osisWork="KJV &amp; co"/></header>
+<div type="book" osisID="psa">
+<title type="main">Psalms</title>
+<chapter osisID="psa.23">
+<p>
+<lg>
+<l><verse osisID="psa.23.1" sID="psa.23.1"/>The Lord is my shepherd;<verse eID="psa.23.1"/></l>
+</lg>
+</p>
+</chapter>
+</div>
+</osisText>
+</osis>
+`
+
+	if sb.String() != expected {
+		t.Errorf("OsisWriter = %q, want %q", sb.String(), expected)
+	}
+}
@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+var (
+	ErrOsisMissingBookId = errors.New("osis book id not found")
+	ErrOsisSyntax        = errors.New("invalid osis xml")
+)
+
+// NOTE: Elements dropped with their content, true if dropped without a
+// diagnostic
+var osisDroppedElements = map[string]bool{
+	"header": true,
+	"figure": false,
+}
+
+// OsisReader reads the books of an OSIS XML document one at a time. Both
+// milestone and container <chapter>, <verse> and <q> elements are supported.
+// Elements with no counterpart in the SDK, e.g. <hi> or <w>, keep their text.
+type OsisReader struct {
+	decoder *xml.Decoder
+}
+
+// NewOsisReader creates an OsisReader reading from r.
+func NewOsisReader(r io.Reader) *OsisReader {
+	return &OsisReader{
+		decoder: xml.NewDecoder(r),
+	}
+}
+
+// ImportOsis reads all the books of an OSIS XML document.
+func ImportOsis(r io.Reader) ([]*BookData, error) {
+	osisReader := NewOsisReader(r)
+	books := make([]*BookData, 0)
+
+	for {
+		book, err := osisReader.Next()
+		if errors.Is(err, io.EOF) {
+			return books, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		books = append(books, book)
+	}
+}
+
+// Next reads the next <div type="book"> of the document. It returns io.EOF
+// after the last book. Mark offsets are rune offsets in the verse text, after
+// notes are stripped.
+func (r *OsisReader) Next() (*BookData, error) {
+	for {
+		token, err := r.decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrOsisSyntax, err)
+		}
+
+		if element, ok := token.(xml.StartElement); ok && element.Name.Local == "div" && xmlAttr(element, "type") == "book" {
+			return r.readBook(element)
+		}
+	}
+}
+
+type osisParser struct {
+	book   *bookBuilder
+	name   textBuffer
+	inName bool
+	// NOTE: sID of the open <q who="Jesus"/> milestones
+	jesusQuotes map[string]bool
+}
+
+func (r *OsisReader) readBook(element xml.StartElement) (*BookData, error) {
+	p := &osisParser{book: newBookBuilder(), jesusQuotes: make(map[string]bool)}
+
+	p.book.code, _, _ = strings.Cut(xmlAttr(element, "osisID"), " ")
+	if p.book.code == "" {
+		return nil, ErrOsisMissingBookId
+	}
+
+	if err := readXmlElement(r.decoder, p.book, p.dropElement, p.openElement, p.text); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOsisSyntax, err)
+	}
+
+	return p.book.finish(p.name.String()), nil
+}
+
+func (p *osisParser) text(text string) {
+	if p.inName {
+		p.name.write(text)
+	} else {
+		p.book.write(text)
+	}
+}
+
+// NOTE: Whether element is dropped with its content
+func (p *osisParser) dropElement(element xml.StartElement) bool {
+	silent, ok := osisDroppedElements[element.Name.Local]
+	if ok && !silent {
+		p.book.diagnose(fmt.Sprintf("unsupported element <%s>, its content is dropped", element.Name.Local))
+	}
+
+	return ok
+}
+
+// NOTE: Handles the start of an element, returns the function to call at its
+// end
+func (p *osisParser) openElement(element xml.StartElement) func() {
+	switch element.Name.Local {
+	case "chapter":
+		// NOTE: The end of a chapter is the start of the next one
+		if xmlAttr(element, "eID") != "" {
+			return nil
+		}
+
+		osisId := xmlAttr(element, "osisID")
+
+		number, err := strconv.Atoi(osisIdLabel(osisId))
+		if err != nil {
+			p.book.diagnose(fmt.Sprintf("invalid chapter osisID %q", osisId))
+
+			return nil
+		}
+
+		p.book.startChapter(number)
+	case "verse":
+		if xmlAttr(element, "eID") != "" {
+			p.book.endVerse()
+
+			return nil
+		}
+
+		osisId := xmlAttr(element, "osisID")
+		label := osisIdLabel(osisId)
+
+		number, err := verseLabelNumber(label)
+		if err != nil && p.book.chapter != nil {
+			p.book.diagnose(fmt.Sprintf("invalid verse osisID %q", osisId))
+		}
+
+		p.book.startVerse(label, number)
+
+		// NOTE: Container verses end with their element
+		if xmlAttr(element, "sID") == "" {
+			return p.book.endVerse
+		}
+	case "title":
+		switch xmlAttr(element, "type") {
+		case "main":
+			p.inName = true
+
+			return func() {
+				p.inName = false
+			}
+		case "psalm":
+			p.book.startPsalm()
+		default:
+			level, err := strconv.Atoi(xmlAttr(element, "level"))
+			if err != nil {
+				level = 1
+			}
+
+			p.book.startHeading(max(level, 1))
+		}
+
+		return p.book.finishBlock
+	case "p":
+		if xmlAttr(element, "type") == "x-continued" {
+			p.book.continueParagraph(false)
+		} else {
+			p.book.startParagraph(false)
+		}
+	case "lg":
+		p.book.continueParagraph(true)
+
+		return func() {
+			p.book.continueParagraph(false)
+		}
+	case "l":
+		p.book.continueParagraph(true)
+	case "note":
+		if xmlAttr(element, "type") == "crossReference" {
+			p.book.openNote(biblev1.MarkKind_MARK_KIND_REFERENCE)
+		} else {
+			p.book.openNote(biblev1.MarkKind_MARK_KIND_FOOTNOTE)
+		}
+
+		return p.book.closeNote
+	case "q":
+		// NOTE: The end milestone may not repeat who="Jesus"
+		if eId := xmlAttr(element, "eID"); eId != "" {
+			if p.jesusQuotes[eId] {
+				delete(p.jesusQuotes, eId)
+				p.book.closeWordsOfJesus()
+			}
+
+			return nil
+		}
+
+		if xmlAttr(element, "who") != "Jesus" {
+			return nil
+		}
+
+		p.book.openWordsOfJesus()
+
+		if sId := xmlAttr(element, "sID"); sId != "" {
+			p.jesusQuotes[sId] = true
+
+			return nil
+		}
+
+		return p.book.closeWordsOfJesus
+	}
+
+	return nil
+}
+
+// NOTE: Returns the last part of the first osisID, e.g. "1" for "Gen.1.1"
+func osisIdLabel(osisId string) string {
+	osisId, _, _ = strings.Cut(osisId, " ")
+
+	return osisId[strings.LastIndex(osisId, ".")+1:]
+}
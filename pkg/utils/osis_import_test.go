@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func TestImportOsis_RoundTrip(t *testing.T) {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+
+	var osis strings.Builder
+
+	if err := ExportOsis(&osis, book, chapters, verses, marks, headings, psalms); err != nil {
+		t.Fatalf("ExportOsis() error = %v", err)
+	}
+
+	books, err := ImportOsis(strings.NewReader(osis.String()))
+	if err != nil {
+		t.Fatalf("ImportOsis() error = %v", err)
+	}
+
+	if len(books) != 1 {
+		t.Fatalf("ImportOsis() = %d books, want 1", len(books))
+	}
+
+	result := books[0]
+
+	if len(result.Diagnostics) > 0 {
+		t.Errorf("ImportOsis() diagnostics = %+v, want none", result.Diagnostics)
+	}
+
+	if len(result.Verses) != len(verses) || len(result.Marks) != len(marks) || len(result.Headings) != len(headings) || len(result.Psalms) != len(psalms) {
+		t.Errorf("ImportOsis() = %d verses, %d marks, %d headings, %d psalms, want %d, %d, %d, %d", len(result.Verses), len(result.Marks), len(result.Headings), len(result.Psalms), len(verses), len(marks), len(headings), len(psalms))
+	}
+
+	var reexported strings.Builder
+
+	if err := ExportOsis(&reexported, result.Book, result.Chapters, result.Verses, result.Marks, result.Headings, result.Psalms); err != nil {
+		t.Fatalf("ExportOsis() error = %v", err)
+	}
+
+	if reexported.String() != osis.String() {
+		t.Errorf("ExportOsis(ImportOsis()) = %q, want %q", reexported.String(), osis.String())
+	}
+}
+
+func TestOsisReader(t *testing.T) {
+	osis := `<?xml version="1.0" encoding="UTF-8"?>
+<osis xmlns="http://www.bibletechnologies.net/2003/OSIS/namespace">
+<osisText osisIDWork="Test">
+<header><work osisWork="Test"><title>Test Bible</title></work></header>
+<div type="bookGroup">
+<div type="book" osisID="John">
+<chapter osisID="John.11">
+<p>
+<verse osisID="John.11.35">Jesus <hi type="italic">wept</hi>.<note type="crossReference"><reference osisRef="Luke.19.41">Lk 19:41</reference></note></verse>
+<verse osisID="John.11.43">He cried, <q who="Jesus">Lazarus,</q></verse>
+<figure src="lazarus.png"><caption>The tomb</caption></figure>
+</p>
+</chapter>
+</div>
+<div type="book" osisID="Acts">
+<chapter sID="Acts.1" osisID="Acts.1"/>
+<div type="section"><title level="2">The Promise</title>
+<p><verse sID="Acts.1.1" osisID="Acts.1.1"/>In the former book<note>Or account</note><verse eID="Acts.1.1"/></p>
+</div>
+<chapter eID="Acts.1"/>
+</div>
+</div>
+</osisText>
+</osis>`
+
+	reader := NewOsisReader(strings.NewReader(osis))
+
+	john, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+
+	expectedVerses := []string{"Jesus wept.", "He cried, Lazarus,"}
+
+	if len(john.Verses) != len(expectedVerses) {
+		t.Fatalf("Next() = %d verses, want %d", len(john.Verses), len(expectedVerses))
+	}
+
+	for i, verse := range john.Verses {
+		if verse.Text != expectedVerses[i] {
+			t.Errorf("Next() verse %d = %q, want %q", i, verse.Text, expectedVerses[i])
+		}
+	}
+
+	if john.Verses[1].Id != "John.11.43" || john.Verses[1].Number != 43 {
+		t.Errorf("Next() verse = %+v, want John.11.43", john.Verses[1])
+	}
+
+	expectedMarks := []*biblev1.Mark{
+		{Kind: biblev1.MarkKind_MARK_KIND_REFERENCE, Content: "Lk 19:41", StartOffset: 11, EndOffset: 11, TargetId: "John.11.35"},
+		{Content: "Lazarus,", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 10, EndOffset: 18, TargetId: "John.11.43"},
+	}
+
+	if len(john.Marks) != len(expectedMarks) {
+		t.Fatalf("Next() marks = %+v, want %+v", john.Marks, expectedMarks)
+	}
+
+	for i, mark := range john.Marks {
+		expected := expectedMarks[i]
+
+		if mark.Kind != expected.Kind || mark.Content != expected.Content || mark.StartOffset != expected.StartOffset || mark.EndOffset != expected.EndOffset || mark.TargetId != expected.TargetId {
+			t.Errorf("Next() mark %d = %+v, want %+v", i, mark, expected)
+		}
+	}
+
+	if len(john.Diagnostics) != 1 || john.Diagnostics[0].Marker != "figure" {
+		t.Errorf("Next() diagnostics = %+v, want figure", john.Diagnostics)
+	}
+
+	acts, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+
+	if len(acts.Verses) != 1 || acts.Verses[0].Text != "In the former book" || acts.Verses[0].ChapterId != "Acts.1" {
+		t.Errorf("Next() verses = %+v, want Acts.1.1", acts.Verses)
+	}
+
+	if len(acts.Headings) != 1 || acts.Headings[0].Text != "The Promise" || acts.Headings[0].Level != 2 || acts.Headings[0].VerseId != "Acts.1.1" {
+		t.Errorf("Next() headings = %+v, want The Promise", acts.Headings)
+	}
+
+	if len(acts.Marks) != 1 || acts.Marks[0].Content != "Or account" || acts.Marks[0].StartOffset != 18 {
+		t.Errorf("Next() marks = %+v, want Or account", acts.Marks)
+	}
+
+	if _, err := reader.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("Next() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestOsisReader_WordsOfJesusMilestones(t *testing.T) {
+	osis := `<osis><osisText><div type="book" osisID="Matt">
+<chapter osisID="Matt.5">
+<p>
+<verse osisID="Matt.5.2">He said, <q sID="q1" who="Jesus"/>Blessed are the poor,</verse>
+<verse osisID="Matt.5.3">blessed are those who mourn.<q eID="q1"/> <q sID="q2" who="Peter"/>Amen.<q eID="q2"/></verse>
+</p>
+</chapter>
+</div></osisText></osis>`
+
+	books, err := ImportOsis(strings.NewReader(osis))
+	if err != nil {
+		t.Fatalf("ImportOsis() error = %v", err)
+	}
+
+	if len(books) != 1 {
+		t.Fatalf("ImportOsis() = %d books, want 1", len(books))
+	}
+
+	expectedMarks := []*biblev1.Mark{
+		{Content: "Blessed are the poor,", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 9, EndOffset: 30, TargetId: "Matt.5.2"},
+		{Content: "blessed are those who mourn.", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 28, TargetId: "Matt.5.3"},
+	}
+
+	if len(books[0].Marks) != len(expectedMarks) {
+		t.Fatalf("ImportOsis() marks = %+v, want %+v", books[0].Marks, expectedMarks)
+	}
+
+	for i, mark := range books[0].Marks {
+		expected := expectedMarks[i]
+
+		if mark.Kind != expected.Kind || mark.Content != expected.Content || mark.StartOffset != expected.StartOffset || mark.EndOffset != expected.EndOffset || mark.TargetId != expected.TargetId {
+			t.Errorf("ImportOsis() mark %d = %+v, want %+v", i, mark, expected)
+		}
+	}
+
+	if len(books[0].Diagnostics) > 0 {
+		t.Errorf("ImportOsis() diagnostics = %+v, want none", books[0].Diagnostics)
+	}
+}
+
+func TestImportOsis_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected error
+	}{
+		{
+			name:     "missing book id",
+			input:    `<osis><div type="book"><chapter osisID="Gen.1"/></div></osis>`,
+			expected: ErrOsisMissingBookId,
+		},
+		{
+			name:     "invalid xml",
+			input:    `<osis><div type="book" osisID="Gen"><chapter osisID="Gen.1">`,
+			expected: ErrOsisSyntax,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ImportOsis(strings.NewReader(tt.input))
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("ImportOsis() error = %v, want %v", err, tt.expected)
+			}
+		})
+	}
+}
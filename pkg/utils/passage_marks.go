@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+var (
+	ErrPassageVerseNotFound = errors.New("passage verse not found")
+	ErrInvalidPassageRange  = errors.New("invalid passage range")
+)
+
+// PassageMark is a mark covering a span of consecutive verses, from
+// StartOffset in the verse StartVerseId to EndOffset in the verse EndVerseId.
+type PassageMark struct {
+	// NOTE: Template of the per-verse marks, its offsets, TargetId, TargetType
+	// and ChapterId are ignored
+	Mark         *biblev1.Mark
+	StartVerseId string
+	StartOffset  int
+	EndVerseId   string
+	EndOffset    int
+}
+
+// SplitPassageMark splits a passage mark into one mark per verse. verses must
+// be in reading order and contain every verse of the passage.
+//
+// When the Content of the mark is the covered text, i.e. it has as many runes
+// as the passage, each mark gets its part of Content. Otherwise, e.g. for the
+// text of a note, every mark keeps the whole Content.
+func SplitPassageMark(passageMark *PassageMark, verses []*biblev1.Verse) ([]*biblev1.Mark, error) {
+	startIdx := slices.IndexFunc(verses, func(v *biblev1.Verse) bool {
+		return v.Id == passageMark.StartVerseId
+	})
+	if startIdx == -1 {
+		return nil, fmt.Errorf("%w: %s", ErrPassageVerseNotFound, passageMark.StartVerseId)
+	}
+
+	endIdx := slices.IndexFunc(verses, func(v *biblev1.Verse) bool {
+		return v.Id == passageMark.EndVerseId
+	})
+	if endIdx == -1 {
+		return nil, fmt.Errorf("%w: %s", ErrPassageVerseNotFound, passageMark.EndVerseId)
+	}
+
+	if startIdx > endIdx || (startIdx == endIdx && passageMark.StartOffset > passageMark.EndOffset) {
+		return nil, ErrInvalidPassageRange
+	}
+
+	// NOTE: A zero-width passage mark is a single anchor in its verse
+	isPoint := startIdx == endIdx && passageMark.StartOffset == passageMark.EndOffset
+
+	marks := make([]*biblev1.Mark, 0, endIdx-startIdx+1)
+	coveredLength := 0
+
+	for i := startIdx; i <= endIdx; i++ {
+		verse := verses[i]
+
+		startOffset := 0
+		endOffset := utf8.RuneCountInString(verse.Text)
+
+		if i == startIdx {
+			startOffset = min(passageMark.StartOffset, endOffset)
+		}
+
+		if i == endIdx {
+			endOffset = min(passageMark.EndOffset, endOffset)
+		}
+
+		// NOTE: Skip empty fragments, e.g. when the passage ends at offset 0 of
+		// the last verse
+		if startOffset >= endOffset && !isPoint {
+			continue
+		}
+
+		newMark := cloneMark(passageMark.Mark)
+		newMark.StartOffset = int32(startOffset)
+		newMark.EndOffset = int32(endOffset)
+		newMark.TargetId = verse.Id
+		newMark.TargetType = biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE
+		newMark.ChapterId = verse.ChapterId
+
+		marks = append(marks, newMark)
+		coveredLength += endOffset - startOffset
+	}
+
+	content := []rune(passageMark.Mark.Content)
+
+	// NOTE: Slice the covered text into the fragments
+	if !isPoint && len(content) == coveredLength {
+		offset := 0
+
+		for _, mark := range marks {
+			length := int(mark.EndOffset - mark.StartOffset)
+			mark.Content = string(content[offset : offset+length])
+			offset += length
+		}
+	}
+
+	return marks, nil
+}
+
+// NOTE: Fragments carry their part of the covered text when Content has as
+// many runes as their span
+func isCoveredContent(mark *biblev1.Mark) bool {
+	return utf8.RuneCountInString(mark.Content) == int(mark.EndOffset-mark.StartOffset)
+}
+
+// MergeVerseMarks is the reverse of SplitPassageMark: per-verse fragments of
+// the same mark (same Id and Kind) are merged when a fragment runs to the end
+// of its verse and the next one starts at the beginning of the following
+// verse. Marks not targeting one of verses are ignored.
+//
+// The Content of fragments holding their covered text is concatenated,
+// otherwise the Content of the first fragment is kept.
+func MergeVerseMarks(marks []*biblev1.Mark, verses []*biblev1.Verse) []*PassageMark {
+	verseIndexes := make(map[string]int, len(verses))
+
+	for i, verse := range verses {
+		verseIndexes[verse.Id] = i
+	}
+
+	fragments := make([]*biblev1.Mark, 0, len(marks))
+
+	for _, mark := range marks {
+		if mark.TargetType != biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE {
+			continue
+		}
+
+		if _, ok := verseIndexes[mark.TargetId]; !ok {
+			continue
+		}
+
+		fragments = append(fragments, mark)
+	}
+
+	slices.SortStableFunc(fragments, func(a, b *biblev1.Mark) int {
+		return cmp.Or(
+			cmp.Compare(a.Kind, b.Kind),
+			cmp.Compare(a.Id, b.Id),
+			cmp.Compare(verseIndexes[a.TargetId], verseIndexes[b.TargetId]),
+			cmp.Compare(a.StartOffset, b.StartOffset),
+		)
+	})
+
+	passageMarks := make([]*PassageMark, 0, len(fragments))
+
+	var (
+		current       *PassageMark
+		currentEndIdx int
+		// NOTE: Whether all fragments of current hold their covered text
+		currentCovered bool
+		firstContent   string
+	)
+
+	for _, fragment := range fragments {
+		verseIdx := verseIndexes[fragment.TargetId]
+
+		if current != nil &&
+			current.Mark.Id == fragment.Id &&
+			current.Mark.Kind == fragment.Kind &&
+			current.Mark.StartOffset != current.Mark.EndOffset &&
+			verseIdx == currentEndIdx+1 &&
+			current.EndOffset == utf8.RuneCountInString(verses[currentEndIdx].Text) &&
+			fragment.StartOffset == 0 &&
+			fragment.StartOffset != fragment.EndOffset {
+			current.EndVerseId = fragment.TargetId
+			current.EndOffset = int(fragment.EndOffset)
+			currentEndIdx = verseIdx
+
+			if currentCovered && isCoveredContent(fragment) {
+				current.Mark.Content += fragment.Content
+			} else {
+				current.Mark.Content = firstContent
+				currentCovered = false
+			}
+
+			continue
+		}
+
+		current = &PassageMark{
+			Mark:         cloneMark(fragment),
+			StartVerseId: fragment.TargetId,
+			StartOffset:  int(fragment.StartOffset),
+			EndVerseId:   fragment.TargetId,
+			EndOffset:    int(fragment.EndOffset),
+		}
+		currentEndIdx = verseIdx
+		currentCovered = isCoveredContent(fragment)
+		firstContent = fragment.Content
+
+		passageMarks = append(passageMarks, current)
+	}
+
+	slices.SortStableFunc(passageMarks, func(a, b *PassageMark) int {
+		return cmp.Or(
+			cmp.Compare(verseIndexes[a.StartVerseId], verseIndexes[b.StartVerseId]),
+			cmp.Compare(a.StartOffset, b.StartOffset),
+		)
+	})
+
+	return passageMarks
+}
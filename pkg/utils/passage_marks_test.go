@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func passageTestVerses() []*biblev1.Verse {
+	return []*biblev1.Verse{
+		{Id: "JHN.14.6", Text: "Jesus said to him, I am the way.", ChapterId: "JHN.14"},
+		{Id: "JHN.14.7", Text: "If you know me, you will know my Father.", ChapterId: "JHN.14"},
+		{Id: "JHN.14.8", Text: "Philip said to him, Lord, show us the Father.", ChapterId: "JHN.14"},
+	}
+}
+
+func TestSplitPassageMark(t *testing.T) {
+	verses := passageTestVerses()
+
+	tests := []struct {
+		name        string
+		passageMark *PassageMark
+		expected    [][3]any
+		expectedErr error
+	}{
+		{
+			name: "span over two verses",
+			passageMark: &PassageMark{
+				Mark:         &biblev1.Mark{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS},
+				StartVerseId: "JHN.14.6",
+				StartOffset:  19,
+				EndVerseId:   "JHN.14.7",
+				EndOffset:    40,
+			},
+			expected: [][3]any{
+				{"JHN.14.6", int32(19), int32(32)},
+				{"JHN.14.7", int32(0), int32(40)},
+			},
+		},
+		{
+			name: "span within one verse",
+			passageMark: &PassageMark{
+				Mark:         &biblev1.Mark{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS},
+				StartVerseId: "JHN.14.6",
+				StartOffset:  19,
+				EndVerseId:   "JHN.14.6",
+				EndOffset:    31,
+			},
+			expected: [][3]any{
+				{"JHN.14.6", int32(19), int32(31)},
+			},
+		},
+		{
+			name: "empty fragment at the start of the last verse is skipped",
+			passageMark: &PassageMark{
+				Mark:         &biblev1.Mark{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS},
+				StartVerseId: "JHN.14.6",
+				StartOffset:  0,
+				EndVerseId:   "JHN.14.8",
+				EndOffset:    0,
+			},
+			expected: [][3]any{
+				{"JHN.14.6", int32(0), int32(32)},
+				{"JHN.14.7", int32(0), int32(40)},
+			},
+		},
+		{
+			name: "zero-width mark",
+			passageMark: &PassageMark{
+				Mark:         &biblev1.Mark{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE},
+				StartVerseId: "JHN.14.7",
+				StartOffset:  15,
+				EndVerseId:   "JHN.14.7",
+				EndOffset:    15,
+			},
+			expected: [][3]any{
+				{"JHN.14.7", int32(15), int32(15)},
+			},
+		},
+		{
+			name: "unknown verse",
+			passageMark: &PassageMark{
+				Mark:         &biblev1.Mark{Id: "woj1"},
+				StartVerseId: "JHN.14.5",
+				EndVerseId:   "JHN.14.6",
+			},
+			expectedErr: ErrPassageVerseNotFound,
+		},
+		{
+			name: "end before start",
+			passageMark: &PassageMark{
+				Mark:         &biblev1.Mark{Id: "woj1"},
+				StartVerseId: "JHN.14.8",
+				EndVerseId:   "JHN.14.6",
+			},
+			expectedErr: ErrInvalidPassageRange,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			marks, err := SplitPassageMark(tt.passageMark, verses)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("SplitPassageMark() error = %v, want %v", err, tt.expectedErr)
+			}
+
+			if len(marks) != len(tt.expected) {
+				t.Fatalf("SplitPassageMark() returned %d marks, want %d", len(marks), len(tt.expected))
+			}
+
+			for i, mark := range marks {
+				got := [3]any{mark.TargetId, mark.StartOffset, mark.EndOffset}
+				if got != tt.expected[i] {
+					t.Errorf("SplitPassageMark() mark %d = %v, want %v", i, got, tt.expected[i])
+				}
+
+				if mark.Id != tt.passageMark.Mark.Id || mark.TargetType != biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE || mark.ChapterId != "JHN.14" {
+					t.Errorf("SplitPassageMark() mark %d has wrong fields: %+v", i, mark)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeVerseMarks(t *testing.T) {
+	verses := passageTestVerses()
+
+	marks := []*biblev1.Mark{
+		{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 40, TargetId: "JHN.14.7", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE},
+		{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 19, EndOffset: 32, TargetId: "JHN.14.6", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE},
+		{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 5, EndOffset: 5, TargetId: "JHN.14.8", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE},
+		// NOTE: Does not run to the end of its verse, so it is not merged
+		{Id: "woj2", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 10, TargetId: "JHN.14.6", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE},
+		{Id: "woj2", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 10, TargetId: "JHN.14.7", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE},
+		{Id: "fn2", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 0, EndOffset: 0, TargetId: "heading1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_HEADING},
+	}
+
+	expected := []PassageMark{
+		{StartVerseId: "JHN.14.6", StartOffset: 0, EndVerseId: "JHN.14.6", EndOffset: 10},
+		{StartVerseId: "JHN.14.6", StartOffset: 19, EndVerseId: "JHN.14.7", EndOffset: 40},
+		{StartVerseId: "JHN.14.7", StartOffset: 0, EndVerseId: "JHN.14.7", EndOffset: 10},
+		{StartVerseId: "JHN.14.8", StartOffset: 5, EndVerseId: "JHN.14.8", EndOffset: 5},
+	}
+	expectedIds := []string{"woj2", "woj1", "woj2", "fn1"}
+
+	result := MergeVerseMarks(marks, verses)
+
+	if len(result) != len(expected) {
+		t.Fatalf("MergeVerseMarks() returned %d passage marks, want %d", len(result), len(expected))
+	}
+
+	for i, passageMark := range result {
+		got := PassageMark{
+			StartVerseId: passageMark.StartVerseId,
+			StartOffset:  passageMark.StartOffset,
+			EndVerseId:   passageMark.EndVerseId,
+			EndOffset:    passageMark.EndOffset,
+		}
+
+		if got != expected[i] || passageMark.Mark.Id != expectedIds[i] {
+			t.Errorf("MergeVerseMarks() passage mark %d = %s %+v, want %s %+v", i, passageMark.Mark.Id, got, expectedIds[i], expected[i])
+		}
+	}
+}
+
+func TestSplitPassageMark_RoundTrip(t *testing.T) {
+	verses := passageTestVerses()
+
+	tests := []struct {
+		name             string
+		content          string
+		expectedContents []string
+	}{
+		{
+			name:             "covered text is sliced per verse",
+			content:          "I am the way.If you know me, you will know my Father.Philip said to him, ",
+			expectedContents: []string{"I am the way.", "If you know me, you will know my Father.", "Philip said to him, "},
+		},
+		{
+			name:             "other content is kept in every verse",
+			content:          "Or the road",
+			expectedContents: []string{"Or the road", "Or the road", "Or the road"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passageMark := &PassageMark{
+				Mark:         &biblev1.Mark{Id: "woj1", Content: tt.content, Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS},
+				StartVerseId: "JHN.14.6",
+				StartOffset:  19,
+				EndVerseId:   "JHN.14.8",
+				EndOffset:    20,
+			}
+
+			marks, err := SplitPassageMark(passageMark, verses)
+			if err != nil {
+				t.Fatalf("SplitPassageMark() error = %v", err)
+			}
+
+			if len(marks) != len(tt.expectedContents) {
+				t.Fatalf("SplitPassageMark() returned %d marks, want %d", len(marks), len(tt.expectedContents))
+			}
+
+			for i, mark := range marks {
+				if mark.Content != tt.expectedContents[i] {
+					t.Errorf("SplitPassageMark() mark %d Content = %q, want %q", i, mark.Content, tt.expectedContents[i])
+				}
+			}
+
+			result := MergeVerseMarks(marks, verses)
+
+			if len(result) != 1 {
+				t.Fatalf("MergeVerseMarks() returned %d passage marks, want 1", len(result))
+			}
+
+			if result[0].StartVerseId != "JHN.14.6" || result[0].StartOffset != 19 || result[0].EndVerseId != "JHN.14.8" || result[0].EndOffset != 20 {
+				t.Errorf("MergeVerseMarks() = %+v, want %+v", result[0], passageMark)
+			}
+
+			if result[0].Mark.Content != tt.content {
+				t.Errorf("MergeVerseMarks() Content = %q, want %q", result[0].Mark.Content, tt.content)
+			}
+		})
+	}
+}
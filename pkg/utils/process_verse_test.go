@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 
 	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
@@ -96,6 +97,72 @@ func TestInjectMarkLabel(t *testing.T) {
 	}
 }
 
+func TestInjectMarkLabel_ZeroWidthMarks(t *testing.T) {
+	labelMap := map[biblev1.MarkKind]func(mark *biblev1.Mark, chapterId string) string{
+		biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark, chapterId string) string {
+			return fmt.Sprintf("[fn%s]", mark.Label)
+		},
+		biblev1.MarkKind_MARK_KIND_REFERENCE: func(mark *biblev1.Mark, chapterId string) string {
+			return fmt.Sprintf("[ref%s]", mark.Label)
+		},
+		biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: func(mark *biblev1.Mark, chapterId string) string {
+			return fmt.Sprintf("<b>%s</b>", mark.Content)
+		},
+	}
+
+	input := "Jesus said, I am the way, the truth, and the life."
+
+	woj := &biblev1.Mark{
+		Id:          "woj1",
+		Content:     "I am the way, the truth, and the life.",
+		Kind:        biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS,
+		StartOffset: 12,
+		EndOffset:   50,
+	}
+
+	tests := []struct {
+		name     string
+		marks    []*biblev1.Mark
+		expected string
+	}{
+		{
+			name: "anchor inside words of Jesus is kept",
+			marks: []*biblev1.Mark{
+				woj,
+				{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, Label: "a", StartOffset: 24, EndOffset: 24},
+			},
+			expected: "Jesus said, <b>I am the way[fna], the truth, and the life.</b>",
+		},
+		{
+			name: "anchors at span edges are placed outside",
+			marks: []*biblev1.Mark{
+				{Id: "fn2", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, Label: "b", StartOffset: 50, EndOffset: 50},
+				woj,
+				{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, Label: "a", StartOffset: 12, EndOffset: 12},
+			},
+			expected: "Jesus said, [fna]<b>I am the way, the truth, and the life.</b>[fnb]",
+		},
+		{
+			name: "anchors sharing an offset are ordered by kind and sort order",
+			marks: []*biblev1.Mark{
+				{Id: "ref1", Kind: biblev1.MarkKind_MARK_KIND_REFERENCE, Label: "1", StartOffset: 10, EndOffset: 10},
+				{Id: "fn2", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, Label: "b", SortOrder: 1, StartOffset: 10, EndOffset: 10},
+				{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, Label: "a", SortOrder: 0, StartOffset: 10, EndOffset: 10},
+			},
+			expected: "Jesus said[fna][fnb][ref1], I am the way, the truth, and the life.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := InjectMarkLabel(input, tt.marks, labelMap)
+			if result != tt.expected {
+				t.Errorf("InjectMarkLabel() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
 func TestProcessVerseMd(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -208,7 +275,7 @@ func TestProcessVerseHtml(t *testing.T) {
 			marks:    []*biblev1.Mark{},
 			headings: []*biblev1.Heading{},
 			psalms:   []*biblev1.PsalmMetadata{},
-			expected: "<sup><b>1</b></sup> In the beginning God created the heavens and the earth.<hr>\n\n<ol></ol>",
+			expected: "<span id=\"v-GEN.1.1\" data-verse=\"1\" data-chapter=\"\"><sup><b>1</b></sup> In the beginning God created the heavens and the earth.</span><hr>\n\n<ol></ol>",
 		},
 		{
 			name: "verse with footnote",
@@ -236,7 +303,7 @@ func TestProcessVerseHtml(t *testing.T) {
 			},
 			headings: []*biblev1.Heading{},
 			psalms:   []*biblev1.PsalmMetadata{},
-			expected: "<sup><b>1</b></sup> In the beginning <sup><a href=\"#fn-1-\" id=\"fnref-1-\">1</a></sup> created the heavens and the earth.<hr>\n\n<ol><li id=\"fn-1-\"><p>God [<a href=\"#fnref-1-\">1</a>]</p></li>\n\n</ol>",
+			expected: "<span id=\"v-GEN.1.1\" data-verse=\"1\" data-chapter=\"\"><sup><b>1</b></sup> In the beginning <sup><a href=\"#fn-1-\" id=\"fnref-1-\">1</a></sup> created the heavens and the earth.</span><hr>\n\n<ol><li id=\"fn-1-\"><p>God [<a href=\"#fnref-1-\">1</a>]</p></li>\n\n</ol>",
 		},
 		{
 			name: "verse with heading",
@@ -258,7 +325,7 @@ func TestProcessVerseHtml(t *testing.T) {
 				},
 			},
 			psalms:   []*biblev1.PsalmMetadata{},
-			expected: "<h1>The Creation of the World</h1>\n<sup><b>1</b></sup> In the beginning God created the heavens and the earth.<hr>\n\n<ol></ol>",
+			expected: "<h1 id=\"h-heading1\">The Creation of the World</h1>\n<span id=\"v-GEN.1.1\" data-verse=\"1\" data-chapter=\"\"><sup><b>1</b></sup> In the beginning God created the heavens and the earth.</span><hr>\n\n<ol></ol>",
 		},
 	}
 
@@ -275,3 +342,254 @@ func TestProcessVerseHtml(t *testing.T) {
 		})
 	}
 }
+
+// NOTE: A synthetic book shaped like Psalms: 150 chapters with a title, a
+// heading, and a footnote, a reference and words of Jesus in every verse
+func benchmarkBook() ([]*biblev1.Verse, []*biblev1.Mark, []*biblev1.Heading, []*biblev1.PsalmMetadata) {
+	verses := make([]*biblev1.Verse, 0)
+	marks := make([]*biblev1.Mark, 0)
+	headings := make([]*biblev1.Heading, 0)
+	psalms := make([]*biblev1.PsalmMetadata, 0)
+
+	for chap := 1; chap <= 150; chap++ {
+		chapterId := fmt.Sprintf("PSA.%d", chap)
+
+		psalms = append(psalms, &biblev1.PsalmMetadata{
+			Id:        fmt.Sprintf("psalm-%d", chap),
+			Text:      "A Psalm of David.",
+			ChapterId: chapterId,
+		})
+
+		for v := 1; v <= 20; v++ {
+			verseId := fmt.Sprintf("%s.%d", chapterId, v)
+
+			verses = append(verses, &biblev1.Verse{
+				Id:              verseId,
+				Number:          int32(v),
+				Label:           fmt.Sprintf("%d", v),
+				Text:            "Blessed is the man who walks not in the counsel of the wicked, nor stands in the way of sinners.",
+				ParagraphNumber: int32(v / 5),
+				ParagraphIndex:  int32(v % 5),
+				IsPoetry:        v%2 == 0,
+				ChapterId:       chapterId,
+			})
+
+			if v == 1 {
+				headings = append(headings, &biblev1.Heading{
+					Id:        fmt.Sprintf("heading-%d", chap),
+					Text:      "The Way of the Righteous",
+					Level:     1,
+					VerseId:   verseId,
+					ChapterId: chapterId,
+				})
+			}
+
+			marks = append(marks,
+				&biblev1.Mark{
+					Id:          fmt.Sprintf("fn-%s", verseId),
+					Content:     "Or *happy*",
+					Kind:        biblev1.MarkKind_MARK_KIND_FOOTNOTE,
+					SortOrder:   int32(v - 1),
+					StartOffset: 7,
+					EndOffset:   7,
+					TargetId:    verseId,
+					TargetType:  biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE,
+					ChapterId:   chapterId,
+				},
+				&biblev1.Mark{
+					Id:          fmt.Sprintf("ref-%s", verseId),
+					Content:     "Jer 17:7",
+					Kind:        biblev1.MarkKind_MARK_KIND_REFERENCE,
+					SortOrder:   int32(v - 1),
+					StartOffset: 60,
+					EndOffset:   60,
+					TargetId:    verseId,
+					TargetType:  biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE,
+					ChapterId:   chapterId,
+				},
+				&biblev1.Mark{
+					Id:          fmt.Sprintf("woj-%s", verseId),
+					Content:     "the way of sinners",
+					Kind:        biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS,
+					StartOffset: 77,
+					EndOffset:   95,
+					TargetId:    verseId,
+					TargetType:  biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE,
+					ChapterId:   chapterId,
+				},
+			)
+		}
+	}
+
+	return verses, marks, headings, psalms
+}
+
+func BenchmarkProcessVerseMd_Book(b *testing.B) {
+	verses, marks, headings, psalms := benchmarkBook()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ProcessVerseMd(verses, marks, headings, psalms); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(verses)), "ns/verse")
+}
+
+func BenchmarkProcessVerseHtml_Book(b *testing.B) {
+	verses, marks, headings, psalms := benchmarkBook()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ProcessVerseHtml(verses, marks, headings, psalms); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(verses)), "ns/verse")
+}
+
+func TestProcessVerseHtml_Concurrent(t *testing.T) {
+	verses, marks, headings, psalms := benchmarkBook()
+	verses = verses[:100]
+
+	expected, err := ProcessVerseHtml(verses, marks, headings, psalms)
+	if err != nil {
+		t.Fatalf("ProcessVerseHtml() error = %v", err)
+	}
+
+	// NOTE: The Markdown converter and patterns are shared by all goroutines
+	var wg sync.WaitGroup
+
+	results := make([]string, 8)
+
+	for i := range results {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			results[i], _ = ProcessVerseHtml(verses, marks, headings, psalms)
+		}()
+	}
+
+	wg.Wait()
+
+	for i, result := range results {
+		if result != expected {
+			t.Errorf("ProcessVerseHtml() in goroutine %d differs from the sequential output", i)
+		}
+	}
+}
+
+func TestProcessVerseHtml_CustomLabels(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "JHN.11.35", Number: 35, Label: "35", Text: "Jesus wept.", ChapterId: "JHN.11"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 10, TargetId: "JHN.11.35", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "JHN.11"},
+		{Id: "fn1", Content: "Or *cried*", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, Label: "a", StartOffset: 10, EndOffset: 10, TargetId: "JHN.11.35", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "JHN.11"},
+	}
+
+	options := &RenderOptions{
+		MarkLabels: map[biblev1.MarkKind]MarkLabelFunc{
+			biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark, chapterId string) string {
+				return fmt.Sprintf(`<sup class="fn">%s</sup>`, mark.Label)
+			},
+		},
+		FootnoteFormatters: map[biblev1.MarkKind]FootnoteFormatFunc{
+			biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark) string {
+				return mark.Label + ". " + mark.Content
+			},
+		},
+	}
+
+	// NOTE: Words of Jesus have no custom label and keep the default one
+	expected := `<span id="v-JHN.11.35" data-verse="35" data-chapter="JHN.11"><sup><b>35</b></sup> <b>Jesus wept</b><sup class="fn">a</sup>.</span><hr>
+
+<ol><li id="fn-1-JHN.11"><p>a. Or <em>cried</em> [<a href="#fnref-1-JHN.11">1</a>]</p></li>
+
+</ol>`
+
+	result, err := ProcessVerseHtml(verses, marks, nil, nil, options)
+	if err != nil {
+		t.Fatalf("ProcessVerseHtml() error = %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("ProcessVerseHtml() = %q, want %q", result, expected)
+	}
+}
+
+func TestProcessVerseHtml_MarkdownOffsets(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "MAT.5.3", Number: 3, Label: "3", Text: "Blessed are the *poor* in spirit & the meek.", ChapterId: "MAT.5"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 16, EndOffset: 32, TargetId: "MAT.5.3", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "MAT.5"},
+		{Id: "fn1", Content: "Note one", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 22, EndOffset: 22, TargetId: "MAT.5.3", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "MAT.5"},
+		{Id: "fn2", Content: "Note two", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, SortOrder: 1, StartOffset: 34, EndOffset: 34, TargetId: "MAT.5.3", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "MAT.5"},
+	}
+
+	// NOTE: Anchors land after the emphasis and the entity, as in Markdown
+	expected := `<span id="v-MAT.5.3" data-verse="3" data-chapter="MAT.5"><sup><b>3</b></sup> Blessed are the <b><em>poor</em><sup><a href="#fn-1-MAT.5" id="fnref-1-MAT.5">1</a></sup> in spirit</b> &amp;<sup><a href="#fn-2-MAT.5" id="fnref-2-MAT.5">2</a></sup> the meek.</span><hr>
+
+<ol><li id="fn-1-MAT.5"><p>Note one [<a href="#fnref-1-MAT.5">1</a>]</p></li>
+
+<li id="fn-2-MAT.5"><p>Note two [<a href="#fnref-2-MAT.5">2</a>]</p></li>
+
+</ol>`
+
+	result, err := ProcessVerseHtml(verses, marks, nil, nil)
+	if err != nil {
+		t.Fatalf("ProcessVerseHtml() error = %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("ProcessVerseHtml() = %q, want %q", result, expected)
+	}
+}
+
+func TestProcessVerseHtml_VerseAnchors(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "PSA.23.1", Number: 1, Label: "1", Text: "The Lord is my shepherd.", IsPoetry: true, ChapterId: "PSA.23"},
+		{Id: "PSA.23.2", Number: 2, Label: "2", Text: "He makes me lie down.", ChapterId: "PSA.23"},
+	}
+	headings := []*biblev1.Heading{
+		{Id: "h1", Text: "The Good Shepherd", Level: 2, VerseId: "PSA.23.1", ChapterId: "PSA.23"},
+	}
+
+	tests := []struct {
+		name     string
+		options  *RenderOptions
+		expected string
+	}{
+		{
+			name: "default",
+			expected: "<h2 id=\"h-h1\">The Good Shepherd</h2>\n\n" +
+				"<blockquote><span id=\"v-PSA.23.1\" data-verse=\"1\" data-chapter=\"PSA.23\"><sup><b>1</b></sup> The Lord is my shepherd.</span></blockquote>\n " +
+				"<span id=\"v-PSA.23.2\" data-verse=\"2\" data-chapter=\"PSA.23\"><sup><b>2</b></sup> He makes me lie down.</span><hr>\n\n<ol></ol>",
+		},
+		{
+			name:    "id prefix",
+			options: &RenderOptions{IdPrefix: "a-", ShowVerseNumbers: boolPtr(false)},
+			expected: "<h2 id=\"a-h-h1\">The Good Shepherd</h2>\n\n" +
+				"<blockquote><span id=\"a-v-PSA.23.1\" data-verse=\"1\" data-chapter=\"PSA.23\">The Lord is my shepherd.</span></blockquote>\n " +
+				"<span id=\"a-v-PSA.23.2\" data-verse=\"2\" data-chapter=\"PSA.23\">He makes me lie down.</span><hr>\n\n<ol></ol>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ProcessVerseHtml(verses, []*biblev1.Mark{}, headings, []*biblev1.PsalmMetadata{}, tt.options)
+			if err != nil {
+				t.Fatalf("ProcessVerseHtml() error = %v", err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("ProcessVerseHtml() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package utils
+
+import (
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+type RebaseFlag int
+
+const (
+	// NOTE: Every rune the mark covered was removed from the text
+	RebaseFlagDeleted RebaseFlag = iota + 1
+	// NOTE: The mark span still exists but too little of it survived the edit
+	RebaseFlagChanged
+)
+
+type FlaggedMark struct {
+	// NOTE: The rebased mark, offsets are a best-effort guess
+	Mark   *biblev1.Mark
+	Reason RebaseFlag
+}
+
+type RebaseMarksResult struct {
+	Marks   []*biblev1.Mark
+	Flagged []FlaggedMark
+}
+
+type RebaseMarksOptions struct {
+	// NOTE: Minimum similarity (0 to 1) between the old and new span text for a
+	// span mark to be kept. Defaults to 0.5
+	MinSimilarity *float64
+}
+
+const defaultRebaseMinSimilarity = 0.5
+
+type diffOpKind int
+
+const (
+	diffOpEqual diffOpKind = iota
+	diffOpInsert
+	diffOpDelete
+)
+
+type diffOp struct {
+	kind   diffOpKind
+	length int
+}
+
+// RebaseMarks moves the rune offsets of marks computed on oldText so they
+// point to the same text in newText. Span marks whose text was removed or
+// rewritten are returned in Flagged instead of Marks. The Content of words of
+// Jesus is set to the text they cover in newText.
+func RebaseMarks(oldText, newText string, marks []*biblev1.Mark, options *RebaseMarksOptions) RebaseMarksResult {
+	minSimilarity := defaultRebaseMinSimilarity
+
+	if options != nil && options.MinSimilarity != nil {
+		minSimilarity = *options.MinSimilarity
+	}
+
+	oldRunes := []rune(oldText)
+	newRunes := []rune(newText)
+
+	ops := diffRunes(oldRunes, newRunes)
+
+	// NOTE: For each old offset, store the new offset before (left) and after
+	// (right) any text inserted at that offset, and how many old runes before
+	// the offset are unchanged
+	leftOffsets := make([]int, len(oldRunes)+1)
+	rightOffsets := make([]int, len(oldRunes)+1)
+	keptRunes := make([]int, len(oldRunes)+1)
+
+	oldPos := 0
+	newPos := 0
+	kept := 0
+
+	for _, op := range ops {
+		switch op.kind {
+		case diffOpInsert:
+			newPos += op.length
+			rightOffsets[oldPos] = newPos
+		case diffOpDelete:
+			for range op.length {
+				oldPos++
+				leftOffsets[oldPos] = newPos
+				rightOffsets[oldPos] = newPos
+				keptRunes[oldPos] = kept
+			}
+		case diffOpEqual:
+			for range op.length {
+				oldPos++
+				newPos++
+				kept++
+				leftOffsets[oldPos] = newPos
+				rightOffsets[oldPos] = newPos
+				keptRunes[oldPos] = kept
+			}
+		}
+	}
+
+	result := RebaseMarksResult{
+		Marks:   make([]*biblev1.Mark, 0, len(marks)),
+		Flagged: make([]FlaggedMark, 0),
+	}
+
+	for _, mark := range marks {
+		newMark := cloneMark(mark)
+
+		startOffset := clampOffset(int(mark.StartOffset), len(oldRunes))
+		endOffset := clampOffset(int(mark.EndOffset), len(oldRunes))
+
+		if startOffset == endOffset {
+			// NOTE: Zero-width marks stay attached to the text before them, so
+			// text inserted at the anchor goes after it
+			newMark.StartOffset = int32(leftOffsets[startOffset])
+			newMark.EndOffset = newMark.StartOffset
+
+			result.Marks = append(result.Marks, newMark)
+
+			continue
+		}
+
+		// NOTE: Text inserted right at the edges of a span is not part of it
+		newMark.StartOffset = int32(rightOffsets[startOffset])
+		newMark.EndOffset = int32(max(leftOffsets[endOffset], rightOffsets[startOffset]))
+
+		if newMark.Kind == biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS {
+			newMark.Content = string(newRunes[newMark.StartOffset:newMark.EndOffset])
+		}
+
+		retained := keptRunes[endOffset] - keptRunes[startOffset]
+		oldLength := endOffset - startOffset
+		newLength := int(newMark.EndOffset - newMark.StartOffset)
+
+		// NOTE: Dice coefficient of the unchanged runes in both spans
+		similarity := 2 * float64(retained) / float64(oldLength+newLength)
+
+		switch {
+		case retained == 0 || newLength == 0:
+			result.Flagged = append(result.Flagged, FlaggedMark{Mark: newMark, Reason: RebaseFlagDeleted})
+		case similarity < minSimilarity:
+			result.Flagged = append(result.Flagged, FlaggedMark{Mark: newMark, Reason: RebaseFlagChanged})
+		default:
+			result.Marks = append(result.Marks, newMark)
+		}
+	}
+
+	return result
+}
+
+func clampOffset(offset, length int) int {
+	if offset < 0 {
+		return 0
+	}
+
+	if offset > length {
+		return length
+	}
+
+	return offset
+}
+
+// NOTE: Myers' O(ND) diff, returns the edit script from a to b with
+// consecutive operations of the same kind merged
+func diffRunes(a, b []rune) []diffOp {
+	n := len(a)
+	m := len(b)
+	maxD := n + m
+	offset := maxD + 1
+
+	v := make([]int, 2*maxD+3)
+	trace := make([][]int, 0)
+
+	for d := 0; d <= maxD; d++ {
+		trace = append(trace, append([]int{}, v...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrackDiff(trace, offset, n, m)
+			}
+		}
+	}
+
+	return backtrackDiff(trace, offset, n, m)
+}
+
+func backtrackDiff(trace [][]int, offset, n, m int) []diffOp {
+	reversedOps := make([]diffOpKind, 0)
+
+	x := n
+	y := m
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversedOps = append(reversedOps, diffOpEqual)
+			x--
+			y--
+		}
+
+		if d > 0 {
+			if x == prevX {
+				reversedOps = append(reversedOps, diffOpInsert)
+			} else {
+				reversedOps = append(reversedOps, diffOpDelete)
+			}
+		}
+
+		x = prevX
+		y = prevY
+	}
+
+	ops := make([]diffOp, 0)
+
+	for i := len(reversedOps) - 1; i >= 0; i-- {
+		kind := reversedOps[i]
+
+		if len(ops) > 0 && ops[len(ops)-1].kind == kind {
+			ops[len(ops)-1].length++
+		} else {
+			ops = append(ops, diffOp{kind: kind, length: 1})
+		}
+	}
+
+	return ops
+}
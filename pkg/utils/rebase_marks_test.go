@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+	"golang.org/x/exp/utf8string"
+)
+
+func TestRebaseMarks(t *testing.T) {
+	tests := []struct {
+		name            string
+		oldText         string
+		newText         string
+		marks           []*biblev1.Mark
+		options         *RebaseMarksOptions
+		expectedSpans   map[string]string
+		expectedOffsets map[string][2]int32
+		expectedFlags   map[string]RebaseFlag
+	}{
+		{
+			name:    "typo fix before marks shifts them",
+			oldText: "In the begining God created the heavens and the earth.",
+			newText: "In the beginning God created the heavens and the earth.",
+			marks: []*biblev1.Mark{
+				{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 16, EndOffset: 27},
+				{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 19, EndOffset: 19},
+			},
+			expectedSpans: map[string]string{
+				"woj1": "God created",
+			},
+			expectedOffsets: map[string][2]int32{
+				"fn1": {20, 20},
+			},
+		},
+		{
+			name:    "edit after marks keeps offsets",
+			oldText: "Jesus wept.",
+			newText: "Jesus wept bitterly.",
+			marks: []*biblev1.Mark{
+				{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 5},
+			},
+			expectedOffsets: map[string][2]int32{
+				"woj1": {0, 5},
+			},
+		},
+		{
+			name:    "Vietnamese text uses rune offsets",
+			oldText: "Lúc khởi đầu đã có Ngôi Lời",
+			newText: "Lúc khởi thủy đã có Ngôi Lời",
+			marks: []*biblev1.Mark{
+				{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 19, EndOffset: 27},
+			},
+			expectedSpans: map[string]string{
+				"woj1": "Ngôi Lời",
+			},
+		},
+		{
+			name:    "zero-width mark stays before inserted text",
+			oldText: "God created the earth.",
+			newText: "God created the heavens and the earth.",
+			marks: []*biblev1.Mark{
+				{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 11, EndOffset: 11},
+			},
+			expectedOffsets: map[string][2]int32{
+				"fn1": {11, 11},
+			},
+		},
+		{
+			name:    "deleted span is flagged",
+			oldText: "He said, truly I tell you, follow me.",
+			newText: "He said, follow me.",
+			marks: []*biblev1.Mark{
+				{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 9, EndOffset: 25},
+				{Id: "woj2", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 27, EndOffset: 36},
+			},
+			expectedSpans: map[string]string{
+				"woj2": "follow me",
+			},
+			expectedFlags: map[string]RebaseFlag{
+				"woj1": RebaseFlagDeleted,
+			},
+		},
+		{
+			name:    "rewritten span is flagged",
+			oldText: "Blessed are the meek.",
+			newText: "Blessed are those who hunger.",
+			marks: []*biblev1.Mark{
+				{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 12, EndOffset: 20},
+			},
+			expectedFlags: map[string]RebaseFlag{
+				"woj1": RebaseFlagChanged,
+			},
+		},
+		{
+			name:    "extended span is kept",
+			oldText: "Blessed are the meek.",
+			newText: "Blessed are the very meek.",
+			marks: []*biblev1.Mark{
+				{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 12, EndOffset: 20, Content: "the meek"},
+			},
+			expectedSpans: map[string]string{
+				"woj1": "the very meek",
+			},
+		},
+		{
+			name:    "extended span is flagged with a higher threshold",
+			oldText: "Blessed are the meek.",
+			newText: "Blessed are the very meek.",
+			marks: []*biblev1.Mark{
+				{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 12, EndOffset: 20},
+			},
+			options: &RebaseMarksOptions{MinSimilarity: float64Ptr(0.9)},
+			expectedFlags: map[string]RebaseFlag{
+				"woj1": RebaseFlagChanged,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := RebaseMarks(tt.oldText, tt.newText, tt.marks, tt.options)
+
+			newText := utf8string.NewString(tt.newText)
+
+			rebased := make(map[string]*biblev1.Mark)
+			for _, mark := range result.Marks {
+				rebased[mark.Id] = mark
+			}
+
+			flagged := make(map[string]RebaseFlag)
+			for _, flaggedMark := range result.Flagged {
+				flagged[flaggedMark.Mark.Id] = flaggedMark.Reason
+			}
+
+			if len(result.Marks)+len(result.Flagged) != len(tt.marks) {
+				t.Errorf("RebaseMarks() returned %d marks and %d flagged, want %d in total", len(result.Marks), len(result.Flagged), len(tt.marks))
+			}
+
+			for id, span := range tt.expectedSpans {
+				mark, ok := rebased[id]
+				if !ok {
+					t.Errorf("RebaseMarks() mark %s missing from result", id)
+					continue
+				}
+
+				got := newText.Slice(int(mark.StartOffset), int(mark.EndOffset))
+				if got != span {
+					t.Errorf("RebaseMarks() mark %s spans %q, want %q", id, got, span)
+				}
+
+				if mark.Kind == biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS && mark.Content != span {
+					t.Errorf("RebaseMarks() mark %s content = %q, want %q", id, mark.Content, span)
+				}
+			}
+
+			for id, offsets := range tt.expectedOffsets {
+				mark, ok := rebased[id]
+				if !ok {
+					t.Errorf("RebaseMarks() mark %s missing from result", id)
+					continue
+				}
+
+				if mark.StartOffset != offsets[0] || mark.EndOffset != offsets[1] {
+					t.Errorf("RebaseMarks() mark %s offsets = [%d, %d], want %v", id, mark.StartOffset, mark.EndOffset, offsets)
+				}
+			}
+
+			for id, reason := range tt.expectedFlags {
+				if flagged[id] != reason {
+					t.Errorf("RebaseMarks() mark %s flag = %d, want %d", id, flagged[id], reason)
+				}
+			}
+		})
+	}
+}
+
+func TestRebaseMarks_DoesNotMutateInput(t *testing.T) {
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 4, EndOffset: 4},
+	}
+
+	RebaseMarks("abc def", "abcX def", marks, nil)
+
+	if marks[0].StartOffset != 4 {
+		t.Errorf("RebaseMarks() mutated input mark: %+v", marks[0])
+	}
+}
+
+func TestDiffRunes(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+	}{
+		{name: "both empty", a: "", b: ""},
+		{name: "insert only", a: "", b: "abc"},
+		{name: "delete only", a: "abc", b: ""},
+		{name: "equal", a: "abc", b: "abc"},
+		{name: "replace", a: "ABCABBA", b: "CBABAC"},
+		{name: "unicode", a: "Đức Chúa Trời", b: "Chúa Trời ơi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := []rune(tt.a)
+			b := []rune(tt.b)
+
+			ops := diffRunes(a, b)
+
+			// NOTE: Replay the script and check it turns a into b
+			result := make([]rune, 0)
+			oldPos := 0
+			newPos := 0
+
+			for _, op := range ops {
+				switch op.kind {
+				case diffOpEqual:
+					result = append(result, a[oldPos:oldPos+op.length]...)
+					oldPos += op.length
+					newPos += op.length
+				case diffOpDelete:
+					oldPos += op.length
+				case diffOpInsert:
+					result = append(result, b[newPos:newPos+op.length]...)
+					newPos += op.length
+				}
+			}
+
+			if oldPos != len(a) || string(result) != tt.b {
+				t.Errorf("diffRunes() replay = %q (consumed %d/%d), want %q", string(result), oldPos, len(a), tt.b)
+			}
+		})
+	}
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
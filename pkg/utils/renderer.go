@@ -0,0 +1,558 @@
+package utils
+
+import (
+	"cmp"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/samber/lo"
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+// Renderer provides the format specific parts of RenderVerses. MdRenderer and
+// HtmlRenderer are the built-in implementations.
+type Renderer interface {
+	// NOTE: Renders verse or heading text with the mark labels injected
+	Inline(text string, marks []*biblev1.Mark, labelMap map[biblev1.MarkKind]MarkLabelFunc) string
+	MarkLabels() map[biblev1.MarkKind]MarkLabelFunc
+	VerseNumber(verse *biblev1.Verse) string
+	Poetry(content string) string
+	PsalmTitle(psalm *biblev1.PsalmMetadata) string
+	// NOTE: content is the heading text already rendered by Inline
+	Heading(heading *biblev1.Heading, content string) string
+	ChapterBreak() string
+	// NOTE: Renders one entry of the footnote section, an empty string skips
+	// the mark
+	Footnote(mark *biblev1.Mark) string
+	// NOTE: Renders a footnote or reference in place of its label, used with
+	// FootnotePlacementInline
+	InlineFootnote(mark *biblev1.Mark) string
+	// NOTE: Renders the section appended after the verses from the unique
+	// footnote entries
+	FootnoteSection(footnotes []string) string
+	// NOTE: Final clean up of the whole output
+	Finalize(output string) string
+}
+
+// NOTE: Runs of blank lines collapsed by the renderers in Finalize
+var redundantNewlinesRegex = regexp.MustCompile(`\n{3,}`)
+
+// VerseRenderer is implemented by renderers wrapping each verse, e.g. in an
+// element carrying its id. content is the rendered verse with its number,
+// before Poetry.
+type VerseRenderer interface {
+	Verse(verse *biblev1.Verse, content string) string
+}
+
+type FootnotePlacement int
+
+const (
+	// NOTE: One footnote section after all verses
+	FootnotePlacementEndOfPassage FootnotePlacement = iota
+	// NOTE: One footnote section after the verses of each chapter
+	FootnotePlacementEndOfChapter
+	// NOTE: Footnotes are rendered in place of their labels, without a
+	// footnote section
+	FootnotePlacementInline
+)
+
+// FootnoteFormatFunc returns the body of a footnote or reference, rendered by
+// the Renderer in place of mark.Content.
+type FootnoteFormatFunc = func(mark *biblev1.Mark) string
+
+// RenderOptions toggles the elements rendered by RenderVerses. Every element
+// is shown when its option is nil.
+type RenderOptions struct {
+	ShowVerseNumbers  *bool
+	ShowHeadings      *bool
+	ShowPsalmTitles   *bool
+	ShowFootnotes     *bool
+	ShowReferences    *bool
+	ShowWordsOfJesus  *bool
+	ShowChapterBreaks *bool
+	FootnotePlacement FootnotePlacement
+	// NOTE: Labels of footnotes and references
+	FootnoteNumbering FootnoteNumbering
+	// NOTE: Prefix of the generated ids, hrefs and Markdown footnote keys,
+	// replaces the IdPrefix of HtmlRenderer and MdRenderer if not empty
+	IdPrefix string
+	// NOTE: Label functions replacing the renderer ones, mark kinds without a
+	// function keep the renderer default
+	MarkLabels map[biblev1.MarkKind]MarkLabelFunc
+	// NOTE: Footnote body formatters per mark kind, mark kinds without a
+	// formatter keep mark.Content
+	FootnoteFormatters map[biblev1.MarkKind]FootnoteFormatFunc
+}
+
+// NOTE: Returns mark with its Content replaced by the body formatter of its
+// kind, or mark itself if there is none
+func formatFootnote(mark *biblev1.Mark, formatters map[biblev1.MarkKind]FootnoteFormatFunc) *biblev1.Mark {
+	formatter, ok := formatters[mark.Kind]
+	if !ok {
+		return mark
+	}
+
+	newMark := cloneMark(mark)
+	newMark.Content = formatter(mark)
+
+	return newMark
+}
+
+func boolOption(value *bool, defaultValue bool) bool {
+	if value == nil {
+		return defaultValue
+	}
+
+	return *value
+}
+
+// RenderVerses renders a passage with renderer, it builds the Document of the
+// passage with BuildDocument and renders it with RenderDocument.
+func RenderVerses(renderer Renderer, verses []*biblev1.Verse, marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata, options *RenderOptions) (string, error) {
+	document, err := BuildDocument(verses, marks, headings, psalms, options)
+	if err != nil {
+		return "", err
+	}
+
+	return RenderDocument(renderer, document, options)
+}
+
+// NOTE: Returns a copy of the built-in renderers with prefix as IdPrefix, so
+// the renderer of the caller is not modified. Other renderers are kept as-is.
+func withIdPrefix(renderer Renderer, prefix string) Renderer {
+	if prefix == "" {
+		return renderer
+	}
+
+	switch r := renderer.(type) {
+	case *HtmlRenderer:
+		newRenderer := *r
+		newRenderer.IdPrefix = prefix
+
+		return &newRenderer
+	case *MdRenderer:
+		newRenderer := *r
+		newRenderer.IdPrefix = prefix
+
+		return &newRenderer
+	default:
+		return renderer
+	}
+}
+
+// NOTE: Footnote entry of the footnote section, with the chapter of its mark
+type footnoteEntry struct {
+	chapterId string
+	entry     string
+}
+
+// NOTE: Renders the chapters of a Document. The paragraph state is kept
+// between calls, so chapters of several documents can be rendered one after
+// another, like StreamVerses does.
+type documentWriter struct {
+	renderer         Renderer
+	numbering        FootnoteNumbering
+	labelMap         map[biblev1.MarkKind]MarkLabelFunc
+	showVerseNumbers bool
+	currPar          int
+}
+
+func newDocumentWriter(renderer Renderer, options *RenderOptions) *documentWriter {
+	// NOTE: The map of the renderer may be shared, e.g. a package-level map
+	labelMap := maps.Clone(renderer.MarkLabels())
+	if labelMap == nil {
+		labelMap = make(map[biblev1.MarkKind]MarkLabelFunc)
+	}
+
+	for _, kind := range []biblev1.MarkKind{biblev1.MarkKind_MARK_KIND_FOOTNOTE, biblev1.MarkKind_MARK_KIND_REFERENCE} {
+		if labelFunc, ok := labelMap[kind]; ok {
+			labelMap[kind] = func(mark *biblev1.Mark, chapterId string) string {
+				return labelFunc(numberedMark(mark, options.FootnoteNumbering), chapterId)
+			}
+		}
+	}
+
+	if options.FootnotePlacement == FootnotePlacementInline {
+		// NOTE: The Content of the notes of a Document is already formatted
+		inlineFootnote := func(mark *biblev1.Mark, chapterId string) string {
+			return renderer.InlineFootnote(numberedMark(mark, options.FootnoteNumbering))
+		}
+
+		labelMap[biblev1.MarkKind_MARK_KIND_FOOTNOTE] = inlineFootnote
+		labelMap[biblev1.MarkKind_MARK_KIND_REFERENCE] = inlineFootnote
+	}
+
+	for kind, labelFunc := range options.MarkLabels {
+		labelMap[kind] = labelFunc
+	}
+
+	return &documentWriter{
+		renderer:         renderer,
+		numbering:        options.FootnoteNumbering,
+		labelMap:         labelMap,
+		showVerseNumbers: boolOption(options.ShowVerseNumbers, true),
+	}
+}
+
+// NOTE: Key of a note of a Document, footnotes and references may share ids
+type documentNoteKey struct {
+	id   string
+	kind string
+}
+
+func documentNotes(notes []*DocumentNote) map[documentNoteKey]*DocumentNote {
+	notesByKey := make(map[documentNoteKey]*DocumentNote, len(notes))
+
+	for _, note := range notes {
+		notesByKey[documentNoteKey{id: note.Id, kind: note.Kind}] = note
+	}
+
+	return notesByKey
+}
+
+// NOTE: Returns the mark of a note, as given to the label functions and
+// Renderer.Footnote
+func documentNoteMark(note *DocumentNote) *biblev1.Mark {
+	return &biblev1.Mark{
+		Id:        note.Id,
+		Content:   note.Content,
+		Kind:      parseDocumentMarkKind(note.Kind),
+		Label:     cmp.Or(note.MarkLabel, note.Label),
+		SortOrder: max(note.Number-1, 0),
+		TargetId:  note.TargetId,
+		ChapterId: note.ChapterId,
+	}
+}
+
+// NOTE: Footnote entries of notes, in their order
+func (w *documentWriter) footnotes(notes []*DocumentNote) []footnoteEntry {
+	footnotes := make([]footnoteEntry, 0, len(notes))
+
+	for _, note := range notes {
+		if entry := w.renderer.Footnote(numberedMark(documentNoteMark(note), w.numbering)); entry != "" {
+			footnotes = append(footnotes, footnoteEntry{chapterId: note.ChapterId, entry: entry})
+		}
+	}
+
+	return footnotes
+}
+
+// NOTE: Returns the text of inline nodes with the marks of their spans and
+// note references, the reverse of documentInlines
+func documentText(nodes []*DocumentNode, chapterId string, notes map[documentNoteKey]*DocumentNote) (string, []*biblev1.Mark) {
+	var sb strings.Builder
+
+	marks := make([]*biblev1.Mark, 0)
+	pos := 0
+
+	var walk func(nodes []*DocumentNode)
+
+	walk = func(nodes []*DocumentNode) {
+		for _, node := range nodes {
+			startOffset := pos
+
+			switch node.Type {
+			case DocumentNodeText:
+				sb.WriteString(node.Text)
+				pos += utf8.RuneCountInString(node.Text)
+			case DocumentNodeSpan:
+				walk(node.Children)
+
+				marks = append(marks, &biblev1.Mark{
+					Kind:        parseDocumentMarkKind(node.Kind),
+					StartOffset: int32(startOffset),
+					EndOffset:   int32(pos),
+					ChapterId:   chapterId,
+				})
+			case DocumentNodeNoteRef:
+				walk(node.Children)
+
+				var mark *biblev1.Mark
+
+				if note, ok := notes[documentNoteKey{id: node.NoteId, kind: node.Kind}]; ok {
+					mark = documentNoteMark(note)
+				} else {
+					mark = &biblev1.Mark{Id: node.NoteId, Kind: parseDocumentMarkKind(node.Kind), Label: node.Label, ChapterId: chapterId}
+				}
+
+				mark.StartOffset = int32(startOffset)
+				mark.EndOffset = int32(pos)
+
+				marks = append(marks, mark)
+			}
+		}
+	}
+
+	walk(nodes)
+
+	return sb.String(), marks
+}
+
+// NOTE: Writes the headings, psalm titles and verses of chapter to sb, notes
+// are the notes of its Document
+func (w *documentWriter) writeChapter(sb *strings.Builder, chapter *DocumentNode, notes map[documentNoteKey]*DocumentNote) {
+	// NOTE: Headings and psalm titles are written with the next verse, so
+	// they come after the paragraph separator
+	var prefix strings.Builder
+
+	// NOTE: The first verse of a chapter follows the chapter break, so the
+	// output of a chapter does not depend on the previous one
+	isFirstVerse := true
+
+	for _, block := range chapter.Children {
+		switch block.Type {
+		case DocumentNodeHeading:
+			text, marks := documentText(block.Children, chapter.Id, notes)
+			heading := &biblev1.Heading{Id: block.Id, Text: text, Level: block.Level, ChapterId: chapter.Id}
+
+			prefix.WriteString(w.renderer.Heading(heading, w.renderer.Inline(text, marks, w.labelMap)))
+
+			continue
+		case DocumentNodePsalmTitle:
+			text, _ := documentText(block.Children, chapter.Id, notes)
+
+			prefix.WriteString(w.renderer.PsalmTitle(&biblev1.PsalmMetadata{Id: block.Id, Text: text, ChapterId: chapter.Id}))
+			prefix.WriteString("\n")
+
+			continue
+		}
+
+		for _, node := range block.Children {
+			text, marks := documentText(node.Children, chapter.Id, notes)
+			verse := &biblev1.Verse{
+				Id:              node.Id,
+				Number:          node.Number,
+				Text:            text,
+				ChapterId:       chapter.Id,
+				IsPoetry:        block.Type == DocumentNodePoetryLine,
+				SubVerseIndex:   node.SubVerseIndex,
+				ParagraphNumber: node.Paragraph,
+				Label:           node.Label,
+			}
+
+			// NOTE: Order is Woj -> Footnote labels -> Verse number -> Poetry
+			// -> Psalms -> Headings
+			content := w.renderer.Inline(text, marks, w.labelMap)
+
+			if w.showVerseNumbers {
+				content = w.renderer.VerseNumber(verse) + " " + content
+			}
+
+			if verseRenderer, ok := w.renderer.(VerseRenderer); ok {
+				content = verseRenderer.Verse(verse, content)
+			}
+
+			if verse.IsPoetry {
+				content = w.renderer.Poetry(content)
+			}
+
+			switch {
+			case isFirstVerse:
+			case int(verse.ParagraphNumber) > w.currPar:
+				sb.WriteString("\n\n")
+			default:
+				sb.WriteString(" ")
+			}
+
+			sb.WriteString(prefix.String())
+			sb.WriteString(content)
+
+			prefix.Reset()
+			w.currPar = int(verse.ParagraphNumber)
+			isFirstVerse = false
+		}
+	}
+}
+
+// RenderDocument renders a Document built by BuildDocument with renderer.
+// options should be the ones given to BuildDocument.
+func RenderDocument(renderer Renderer, document *Document, options *RenderOptions) (string, error) {
+	if options == nil {
+		options = &RenderOptions{}
+	}
+
+	showChapterBreaks := boolOption(options.ShowChapterBreaks, true)
+
+	renderer = withIdPrefix(renderer, options.IdPrefix)
+	documentWriter := newDocumentWriter(renderer, options)
+
+	// NOTE: Footnote entries in the (Kind, SortOrder) order of the marks
+	footnotes := documentWriter.footnotes(document.Notes)
+	notes := documentNotes(document.Notes)
+
+	// NOTE: Takes the entries matching keep out of footnotes, without
+	// duplicates
+	takeFootnotes := func(keep func(footnote footnoteEntry) bool) []string {
+		taken := make([]string, 0)
+
+		footnotes = slices.DeleteFunc(footnotes, func(footnote footnoteEntry) bool {
+			if keep(footnote) {
+				taken = append(taken, footnote.entry)
+
+				return true
+			}
+
+			return false
+		})
+
+		// NOTE: Remove duplicate footnotes and references
+		return lo.Uniq(taken)
+	}
+
+	var sb strings.Builder
+
+	// NOTE: Store to add newlines between chapters
+	currentChapterId := ""
+
+	for _, chapter := range document.Chapters {
+		// NOTE: Add line break between chapters
+		if currentChapterId != "" {
+			if options.FootnotePlacement == FootnotePlacementEndOfChapter {
+				chapterFootnotes := takeFootnotes(func(footnote footnoteEntry) bool {
+					return footnote.chapterId == currentChapterId
+				})
+
+				if len(chapterFootnotes) > 0 {
+					sb.WriteString(renderer.FootnoteSection(chapterFootnotes))
+				}
+			}
+
+			if showChapterBreaks {
+				sb.WriteString(renderer.ChapterBreak())
+			} else {
+				sb.WriteString("\n\n")
+			}
+		}
+
+		currentChapterId = chapter.Id
+
+		documentWriter.writeChapter(&sb, chapter, notes)
+	}
+
+	if options.FootnotePlacement != FootnotePlacementInline {
+		// NOTE: With FootnotePlacementEndOfChapter, only the last chapter and
+		// chapters without rendered verses are left
+		remainingFootnotes := takeFootnotes(func(footnote footnoteEntry) bool {
+			return true
+		})
+
+		if options.FootnotePlacement == FootnotePlacementEndOfPassage || len(remainingFootnotes) > 0 {
+			sb.WriteString(renderer.FootnoteSection(remainingFootnotes))
+		}
+	}
+
+	return renderer.Finalize(sb.String()), nil
+}
+
+// NOTE: Joins footnote entries the way both built-in renderers lay them out
+func joinFootnotes(footnotes []string) string {
+	var sb strings.Builder
+
+	for _, footnote := range footnotes {
+		sb.WriteString(footnote)
+		sb.WriteString("\n\n")
+	}
+
+	return sb.String()
+}
+
+// NOTE: First rune of the mark placeholders, in the Supplementary Private Use
+// Area-A. Mark i uses base+2i for its start and base+2i+1 for its end.
+const markPlaceholderBase = 0xF0000
+
+// NOTE: Converts text with convert, e.g. from Markdown to HTML, with the mark
+// offsets mapped to the converted text. A placeholder rune is inserted at each
+// mark boundary before the conversion, the new offsets are the positions of
+// the placeholders in the converted text.
+func convertWithMarks(text string, marks []*biblev1.Mark, convert func(string) string) (string, []*biblev1.Mark) {
+	if len(marks) == 0 {
+		return convert(text), marks
+	}
+
+	snappedMarks := snapMarksToGraphemes(text, marks)
+
+	placeholders := make(map[int][]rune)
+
+	for i, mark := range snappedMarks {
+		startOffset := max(int(mark.StartOffset), 0)
+		endOffset := max(int(mark.EndOffset), startOffset)
+
+		placeholders[startOffset] = append(placeholders[startOffset], rune(markPlaceholderBase+2*i))
+
+		if startOffset != endOffset {
+			placeholders[endOffset] = append(placeholders[endOffset], rune(markPlaceholderBase+2*i+1))
+		}
+	}
+
+	var sb strings.Builder
+
+	runes := []rune(text)
+
+	for i := 0; i <= len(runes); i++ {
+		for _, placeholder := range placeholders[i] {
+			sb.WriteRune(placeholder)
+		}
+
+		if i < len(runes) {
+			sb.WriteRune(runes[i])
+		}
+	}
+
+	startOffsets := make(map[int]int32)
+	endOffsets := make(map[int]int32)
+
+	var output strings.Builder
+
+	pos := int32(0)
+
+	for _, c := range convert(sb.String()) {
+		if c >= markPlaceholderBase && c < rune(markPlaceholderBase+2*len(snappedMarks)) {
+			idx := int(c - markPlaceholderBase)
+
+			if idx%2 == 0 {
+				startOffsets[idx/2] = pos
+			} else {
+				endOffsets[idx/2] = pos
+			}
+
+			continue
+		}
+
+		output.WriteRune(c)
+		pos++
+	}
+
+	newMarks := make([]*biblev1.Mark, 0, len(snappedMarks))
+
+	for i, mark := range snappedMarks {
+		startOffset, hasStart := startOffsets[i]
+
+		if mark.StartOffset >= mark.EndOffset {
+			// NOTE: The placeholder is lost if the conversion dropped it, e.g.
+			// inside an element removed by the sanitiser, keep the anchor at
+			// the end
+			if !hasStart {
+				startOffset = pos
+			}
+
+			mark.StartOffset = startOffset
+			mark.EndOffset = startOffset
+		} else {
+			endOffset, hasEnd := endOffsets[i]
+
+			if !hasStart || !hasEnd || startOffset > endOffset {
+				continue
+			}
+
+			mark.StartOffset = startOffset
+			mark.EndOffset = endOffset
+		}
+
+		newMarks = append(newMarks, mark)
+	}
+
+	return output.String(), newMarks
+}
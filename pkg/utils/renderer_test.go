@@ -0,0 +1,348 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+// NOTE: Minimal renderer to check RenderVerses calls every hook
+type testRenderer struct{}
+
+func (r *testRenderer) Inline(text string, marks []*biblev1.Mark, labelMap map[biblev1.MarkKind]MarkLabelFunc) string {
+	return InjectMarkLabel(text, marks, labelMap)
+}
+
+func (r *testRenderer) MarkLabels() map[biblev1.MarkKind]MarkLabelFunc {
+	return map[biblev1.MarkKind]MarkLabelFunc{
+		biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark, chapterId string) string {
+			return fmt.Sprintf("{fn%d}", mark.SortOrder+1)
+		},
+		biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: func(mark *biblev1.Mark, chapterId string) string {
+			return fmt.Sprintf("{woj %s}", mark.Content)
+		},
+	}
+}
+
+func (r *testRenderer) VerseNumber(verse *biblev1.Verse) string {
+	return fmt.Sprintf("(%s)", verse.Label)
+}
+
+func (r *testRenderer) Poetry(content string) string {
+	return "{poetry " + content + "}"
+}
+
+func (r *testRenderer) PsalmTitle(psalm *biblev1.PsalmMetadata) string {
+	return "{title " + psalm.Text + "}"
+}
+
+func (r *testRenderer) Heading(heading *biblev1.Heading, content string) string {
+	return fmt.Sprintf("{h%d %s}\n", heading.Level, content)
+}
+
+func (r *testRenderer) ChapterBreak() string {
+	return "\n{break}"
+}
+
+func (r *testRenderer) Footnote(mark *biblev1.Mark) string {
+	if mark.Kind != biblev1.MarkKind_MARK_KIND_FOOTNOTE {
+		return ""
+	}
+
+	return fmt.Sprintf("{note%d %s}", mark.SortOrder+1, mark.Content)
+}
+
+func (r *testRenderer) InlineFootnote(mark *biblev1.Mark) string {
+	return "{inline " + mark.Content + "}"
+}
+
+func (r *testRenderer) FootnoteSection(footnotes []string) string {
+	return "\n" + strings.Join(footnotes, "\n")
+}
+
+func (r *testRenderer) Finalize(output string) string {
+	return strings.TrimSpace(output)
+}
+
+func TestRenderVerses_CustomRenderer(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "PSA.23.1", Label: "1", Text: "The Lord is my shepherd.", ChapterId: "PSA.23"},
+		{Id: "PSA.23.2", Label: "2", Text: "He makes me lie down.", ParagraphNumber: 1, IsPoetry: true, ChapterId: "PSA.23"},
+		{Id: "PSA.24.1", Label: "1", Text: "The earth is the Lord's.", ChapterId: "PSA.24"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Content: "Or keeper", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 23, EndOffset: 23, TargetId: "PSA.23.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+		// NOTE: Duplicate footnote entries are only rendered once
+		{Id: "fn1", Content: "Or keeper", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 23, EndOffset: 23, TargetId: "PSA.23.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+		{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 8, TargetId: "PSA.23.2", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+	}
+	headings := []*biblev1.Heading{
+		{Id: "h1", Text: "The Good Shepherd", Level: 1, VerseId: "PSA.23.1", ChapterId: "PSA.23"},
+	}
+	psalms := []*biblev1.PsalmMetadata{
+		{Id: "p1", Text: "A Psalm of David.", ChapterId: "PSA.23"},
+	}
+
+	expected := "{h1 The Good Shepherd}\n" +
+		"{title A Psalm of David.}\n(1) The Lord is my shepherd{fn1}{fn1}.\n\n" +
+		"{poetry (2) {woj He makes} me lie down.}\n{break}(1) The earth is the Lord's.\n" +
+		"{note1 Or keeper}"
+
+	result, err := RenderVerses(&testRenderer{}, verses, marks, headings, psalms, nil)
+	if err != nil {
+		t.Fatalf("RenderVerses() error = %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("RenderVerses() = %q, want %q", result, expected)
+	}
+}
+
+func TestRenderVerses_Options(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "PSA.23.1", Label: "1", Text: "The Lord is my shepherd.", ChapterId: "PSA.23"},
+		{Id: "PSA.23.2", Label: "2", Text: "He makes me lie down.", ParagraphNumber: 1, IsPoetry: true, ChapterId: "PSA.23"},
+		{Id: "PSA.24.1", Label: "1", Text: "The earth is the Lord's.", ChapterId: "PSA.24"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Content: "Or keeper", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 23, EndOffset: 23, TargetId: "PSA.23.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+		{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 8, TargetId: "PSA.23.2", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+		{Id: "fn2", Content: "Or world", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, SortOrder: 1, StartOffset: 23, EndOffset: 23, TargetId: "PSA.24.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.24"},
+	}
+	headings := []*biblev1.Heading{
+		{Id: "h1", Text: "The Good Shepherd", Level: 1, VerseId: "PSA.23.1", ChapterId: "PSA.23"},
+	}
+	psalms := []*biblev1.PsalmMetadata{
+		{Id: "p1", Text: "A Psalm of David.", ChapterId: "PSA.23"},
+	}
+
+	tests := []struct {
+		name     string
+		options  *RenderOptions
+		expected string
+	}{
+		{
+			name: "hide verse numbers, headings, titles, words of Jesus and chapter breaks",
+			options: &RenderOptions{
+				ShowVerseNumbers:  boolPtr(false),
+				ShowHeadings:      boolPtr(false),
+				ShowPsalmTitles:   boolPtr(false),
+				ShowWordsOfJesus:  boolPtr(false),
+				ShowChapterBreaks: boolPtr(false),
+			},
+			expected: "The Lord is my shepherd{fn1}.\n\n" +
+				"{poetry He makes me lie down.}\n\nThe earth is the Lord's{fn2}.\n" +
+				"{note1 Or keeper}\n{note2 Or world}",
+		},
+		{
+			name:    "hide footnotes",
+			options: &RenderOptions{ShowFootnotes: boolPtr(false)},
+			expected: "{h1 The Good Shepherd}\n" +
+				"{title A Psalm of David.}\n(1) The Lord is my shepherd.\n\n" +
+				"{poetry (2) {woj He makes} me lie down.}\n{break}(1) The earth is the Lord's.",
+		},
+		{
+			name:    "footnotes at the end of each chapter",
+			options: &RenderOptions{FootnotePlacement: FootnotePlacementEndOfChapter},
+			expected: "{h1 The Good Shepherd}\n" +
+				"{title A Psalm of David.}\n(1) The Lord is my shepherd{fn1}.\n\n" +
+				"{poetry (2) {woj He makes} me lie down.}\n{note1 Or keeper}\n" +
+				"{break}(1) The earth is the Lord's{fn2}.\n{note2 Or world}",
+		},
+		{
+			name:    "inline footnotes",
+			options: &RenderOptions{FootnotePlacement: FootnotePlacementInline},
+			expected: "{h1 The Good Shepherd}\n" +
+				"{title A Psalm of David.}\n(1) The Lord is my shepherd{inline Or keeper}.\n\n" +
+				"{poetry (2) {woj He makes} me lie down.}\n{break}(1) The earth is the Lord's{inline Or world}.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := RenderVerses(&testRenderer{}, verses, marks, headings, psalms, tt.options)
+			if err != nil {
+				t.Fatalf("RenderVerses() error = %v", err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("RenderVerses() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRenderVerses_DoesNotMutateInput(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "GEN.1.1", Label: "1", Text: "In the beginning God created the heavens and the earth.", ChapterId: "GEN.1"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "ref1", Content: "John 1:1", Kind: biblev1.MarkKind_MARK_KIND_REFERENCE, StartOffset: 16, EndOffset: 16, TargetId: "GEN.1.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "GEN.1"},
+		{Id: "fn1", Content: "Or When God began to create", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 16, EndOffset: 16, TargetId: "GEN.1.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "GEN.1"},
+	}
+
+	for _, renderer := range []Renderer{&MdRenderer{}, &HtmlRenderer{}} {
+		if _, err := RenderVerses(renderer, verses, marks, nil, nil, nil); err != nil {
+			t.Fatalf("RenderVerses() error = %v", err)
+		}
+
+		if verses[0].Text != "In the beginning God created the heavens and the earth." {
+			t.Errorf("RenderVerses() mutated verse text: %q", verses[0].Text)
+		}
+
+		if marks[0].Id != "ref1" || marks[0].StartOffset != 16 {
+			t.Errorf("RenderVerses() mutated marks: %+v", marks[0])
+		}
+	}
+}
+
+// NOTE: Renderer returning the same label map on every call
+type sharedLabelsRenderer struct {
+	testRenderer
+}
+
+var sharedTestLabels = (&testRenderer{}).MarkLabels()
+
+func (r *sharedLabelsRenderer) MarkLabels() map[biblev1.MarkKind]MarkLabelFunc {
+	return sharedTestLabels
+}
+
+func TestRenderVerses_DoesNotMutateRendererLabels(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "GEN.1.1", Label: "1", Text: "In the beginning.", ChapterId: "GEN.1"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Content: "Or When", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 16, EndOffset: 16, TargetId: "GEN.1.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "GEN.1"},
+	}
+	options := &RenderOptions{
+		FootnotePlacement: FootnotePlacementInline,
+		MarkLabels: map[biblev1.MarkKind]MarkLabelFunc{
+			biblev1.MarkKind_MARK_KIND_REFERENCE: func(mark *biblev1.Mark, chapterId string) string {
+				return "{ref}"
+			},
+		},
+	}
+
+	expected, err := RenderVerses(&sharedLabelsRenderer{}, verses, marks, nil, nil, options)
+	if err != nil {
+		t.Fatalf("RenderVerses() error = %v", err)
+	}
+
+	result, err := RenderVerses(&sharedLabelsRenderer{}, verses, marks, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("RenderVerses() error = %v", err)
+	}
+
+	if result == expected {
+		t.Errorf("RenderVerses() kept the options of the previous call: %q", result)
+	}
+
+	if len(sharedTestLabels) != 2 {
+		t.Errorf("RenderVerses() mutated the renderer label map: %d labels, want 2", len(sharedTestLabels))
+	}
+}
+
+func TestRenderVerses_IdPrefix(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "JHN.3.16", Number: 16, Label: "16", Text: "For God so loved the world.", ChapterId: "JHN.3"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Content: "Or *cosmos*", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 26, EndOffset: 26, TargetId: "JHN.3.16", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "JHN.3"},
+		{Id: "ref1", Content: "Rom 5:8", Kind: biblev1.MarkKind_MARK_KIND_REFERENCE, StartOffset: 26, EndOffset: 26, TargetId: "JHN.3.16", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "JHN.3"},
+	}
+
+	tests := []struct {
+		name     string
+		renderer Renderer
+		options  *RenderOptions
+		expected string
+	}{
+		{
+			name:     "html option",
+			renderer: &HtmlRenderer{},
+			options:  &RenderOptions{IdPrefix: "a-"},
+			expected: `<span id="a-v-JHN.3.16" data-verse="16" data-chapter="JHN.3"><sup><b>16</b></sup> For God so loved the world<sup><a href="#a-fn-1-JHN.3" id="a-fnref-1-JHN.3">1</a></sup><sup><a href="#a-fn-1@-JHN.3" id="a-fnref-1@-JHN.3">1@</a></sup>.</span><hr>` + "\n\n" +
+				`<ol><li id="a-fn-1-JHN.3"><p>Or <em>cosmos</em> [<a href="#a-fnref-1-JHN.3">1</a>]</p></li>` + "\n\n" +
+				`<li id="a-fn-1@-JHN.3"><p>Rom 5:8 [<a href="#a-fnref-1@-JHN.3">1@</a>]</p></li>` + "\n\n</ol>",
+		},
+		{
+			name:     "html renderer field is escaped",
+			renderer: &HtmlRenderer{IdPrefix: `"b-`},
+			options:  &RenderOptions{ShowReferences: boolPtr(false), ShowVerseNumbers: boolPtr(false)},
+			expected: `<span id="&quot;b-v-JHN.3.16" data-verse="16" data-chapter="JHN.3">For God so loved the world<sup><a href="#&quot;b-fn-1-JHN.3" id="&quot;b-fnref-1-JHN.3">1</a></sup>.</span><hr>` + "\n\n" +
+				`<ol><li id="&quot;b-fn-1-JHN.3"><p>Or <em>cosmos</em> [<a href="#&quot;b-fnref-1-JHN.3">1</a>]</p></li>` + "\n\n</ol>",
+		},
+		{
+			name:     "markdown option replaces renderer field",
+			renderer: &MdRenderer{IdPrefix: "b-"},
+			options:  &RenderOptions{IdPrefix: "a-", ShowVerseNumbers: boolPtr(false)},
+			expected: "For God so loved the world[^a-1-JHN.3][^a-1@-JHN.3].\n\n" +
+				"[^a-1-JHN.3]: Or *cosmos*\n\n" +
+				"[^a-1@-JHN.3]: Rom 5:8",
+		},
+		{
+			name:     "markdown footnote keys are escaped",
+			renderer: &MdRenderer{},
+			options:  &RenderOptions{IdPrefix: "my ]pre^fix", ShowReferences: boolPtr(false), ShowVerseNumbers: boolPtr(false)},
+			expected: "For God so loved the world[^my--pre-fix1-JHN.3].\n\n" +
+				"[^my--pre-fix1-JHN.3]: Or *cosmos*",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := RenderVerses(tt.renderer, verses, marks, nil, nil, tt.options)
+			if err != nil {
+				t.Fatalf("RenderVerses() error = %v", err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("RenderVerses() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+
+	// NOTE: The renderer of the caller is not modified by the option
+	renderer := &MdRenderer{}
+
+	if _, err := RenderVerses(renderer, verses, marks, nil, nil, &RenderOptions{IdPrefix: "a-"}); err != nil {
+		t.Fatalf("RenderVerses() error = %v", err)
+	}
+
+	if renderer.IdPrefix != "" {
+		t.Errorf("RenderVerses() set IdPrefix = %q on the renderer", renderer.IdPrefix)
+	}
+}
+
+func TestRenderVerses_SplitPassageFootnote(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "MAT.5.3", Number: 3, Label: "3", Text: "Blessed are the poor.", ChapterId: "MAT.5"},
+		{Id: "MAT.5.4", Number: 4, Label: "4", Text: "Blessed are those who mourn.", ChapterId: "MAT.5"},
+	}
+
+	marks, err := SplitPassageMark(&PassageMark{
+		Mark:         &biblev1.Mark{Id: "fn1", Content: "The Beatitudes", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE},
+		StartVerseId: "MAT.5.3",
+		StartOffset:  0,
+		EndVerseId:   "MAT.5.4",
+		EndOffset:    27,
+	}, verses)
+	if err != nil {
+		t.Fatalf("SplitPassageMark() error = %v", err)
+	}
+
+	expected := `<span id="v-MAT.5.3" data-verse="3" data-chapter="MAT.5"><sup><b>3</b></sup> Blessed are the poor.</span> ` +
+		`<span id="v-MAT.5.4" data-verse="4" data-chapter="MAT.5"><sup><b>4</b></sup> Blessed are those who mourn<sup><a href="#fn-1-MAT.5" id="fnref-1-MAT.5">1</a></sup>.</span><hr>` + "\n\n" +
+		`<ol><li id="fn-1-MAT.5"><p>The Beatitudes [<a href="#fnref-1-MAT.5">1</a>]</p></li>` + "\n\n</ol>"
+
+	result, err := RenderVerses(&HtmlRenderer{}, verses, marks, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("RenderVerses() error = %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("RenderVerses() = %q, want %q", result, expected)
+	}
+}
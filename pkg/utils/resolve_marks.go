@@ -110,7 +110,62 @@ func ResolveMarks(marks []*biblev1.Mark, options *ResolveMarksOptions) []*biblev
 	// NOTE: Return the resolved annotations with additional annotations
 	sortedMarks = append(sortedMarks, additionalMarks...)
 
-	return slices.SortedFunc(slices.Values(sortedMarks), func(a, b *biblev1.Mark) int {
-		return cmp.Or(cmp.Compare(a.StartOffset, b.StartOffset), cmp.Compare(a.EndOffset, b.EndOffset))
-	})
+	return slices.SortedFunc(slices.Values(sortedMarks), compareMarkPosition)
+}
+
+// NOTE: Orders marks by offsets, then marks sharing the same offsets (e.g.
+// several zero-width anchors at one point) by kind, sort order and id, so the
+// result never depends on the input order
+func compareMarkPosition(a, b *biblev1.Mark) int {
+	return cmp.Or(
+		cmp.Compare(a.StartOffset, b.StartOffset),
+		cmp.Compare(a.EndOffset, b.EndOffset),
+		compareMarkIdentity(a, b),
+	)
+}
+
+// NOTE: Same as compareMarkPosition, but a span comes before the spans it
+// encloses, and zero-width marks come before spans starting at the same offset
+func compareMarkNesting(a, b *biblev1.Mark) int {
+	aIsPoint := a.StartOffset == a.EndOffset
+	bIsPoint := b.StartOffset == b.EndOffset
+
+	if c := cmp.Compare(a.StartOffset, b.StartOffset); c != 0 {
+		return c
+	}
+
+	if aIsPoint != bIsPoint {
+		if aIsPoint {
+			return -1
+		}
+
+		return 1
+	}
+
+	return cmp.Or(cmp.Compare(b.EndOffset, a.EndOffset), compareMarkIdentity(a, b))
+}
+
+func compareMarkIdentity(a, b *biblev1.Mark) int {
+	return cmp.Or(
+		cmp.Compare(a.Kind, b.Kind),
+		cmp.Compare(a.SortOrder, b.SortOrder),
+		cmp.Compare(a.Id, b.Id),
+	)
+}
+
+func cloneMark(mark *biblev1.Mark) *biblev1.Mark {
+	return &biblev1.Mark{
+		Id:          mark.Id,
+		Content:     mark.Content,
+		Kind:        mark.Kind,
+		Label:       mark.Label,
+		SortOrder:   mark.SortOrder,
+		StartOffset: mark.StartOffset,
+		EndOffset:   mark.EndOffset,
+		CreatedAt:   mark.CreatedAt,
+		UpdatedAt:   mark.UpdatedAt,
+		TargetId:    mark.TargetId,
+		TargetType:  mark.TargetType,
+		ChapterId:   mark.ChapterId,
+	}
 }
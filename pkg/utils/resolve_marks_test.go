@@ -564,6 +564,33 @@ func TestResolveMarks_EdgeCases(t *testing.T) {
 	})
 }
 
+func TestResolveMarks_ZeroWidthOrdering(t *testing.T) {
+	marks := []*biblev1.Mark{
+		{Id: "ref1", Kind: biblev1.MarkKind_MARK_KIND_REFERENCE, SortOrder: 0, StartOffset: 10, EndOffset: 10},
+		{Id: "fn2", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, SortOrder: 1, StartOffset: 10, EndOffset: 10},
+		{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 20},
+		{Id: "fn1", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, SortOrder: 0, StartOffset: 10, EndOffset: 10},
+	}
+
+	expectedIds := []string{"woj1", "fn1", "fn2", "ref1"}
+
+	// NOTE: The result must not depend on the input order
+	for i := range marks {
+		rotated := append(append([]*biblev1.Mark{}, marks[i:]...), marks[:i]...)
+
+		result := ResolveMarks(rotated, nil)
+
+		ids := make([]string, 0, len(result))
+		for _, mark := range result {
+			ids = append(ids, mark.Id)
+		}
+
+		if !reflect.DeepEqual(ids, expectedIds) {
+			t.Errorf("ResolveMarks() order = %v, want %v", ids, expectedIds)
+		}
+	}
+}
+
 // Helper function to create a bool pointer
 func boolPtr(b bool) *bool {
 	return &b
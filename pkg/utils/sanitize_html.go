@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// HtmlPolicy is the allow-list used by SanitizeHtml.
+type HtmlPolicy struct {
+	// NOTE: Allowed tags with their allowed attributes
+	Tags map[string][]string
+	// NOTE: Allowed URL schemes of href attributes, relative URLs are always
+	// allowed
+	UrlSchemes []string
+}
+
+// NOTE: Elements dropped with their content, every other element not allowed
+// is dropped but its content is kept
+var htmlDroppedContentTags = []string{
+	"script", "style", "iframe", "object", "embed", "template", "noscript",
+	"textarea", "title", "svg", "math",
+}
+
+// NOTE: Elements without an end tag
+var htmlVoidTags = []string{
+	"area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta",
+	"source", "track", "wbr",
+}
+
+var htmlGlobalAttrs = []string{"class", "lang", "dir", "title"}
+
+// NOTE: Same escaping as goldmark, so apostrophes are kept as-is
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+// DefaultHtmlPolicy allows inline formatting, lists, headings, links and the
+// elements produced by Markdown conversion.
+func DefaultHtmlPolicy() *HtmlPolicy {
+	return &HtmlPolicy{
+		Tags: map[string][]string{
+			"a":          {"href"},
+			"b":          {},
+			"blockquote": {},
+			"br":         {},
+			"code":       {},
+			"del":        {},
+			"em":         {},
+			"h1":         {},
+			"h2":         {},
+			"h3":         {},
+			"h4":         {},
+			"h5":         {},
+			"h6":         {},
+			"hr":         {},
+			"i":          {},
+			"li":         {},
+			"mark":       {},
+			"ol":         {"start"},
+			"p":          {},
+			"s":          {},
+			"small":      {},
+			"span":       {},
+			"strong":     {},
+			"sub":        {},
+			"sup":        {},
+			"u":          {},
+			"ul":         {},
+		},
+		UrlSchemes: []string{"http", "https", "mailto"},
+	}
+}
+
+// NOTE: Shared by SanitizeHtml calls without a policy, it is only read
+var defaultHtmlPolicy = DefaultHtmlPolicy()
+
+// SanitizeHtml removes the tags and attributes not allowed by policy from an
+// HTML fragment. Text is re-escaped, comments and the content of script-like
+// elements are dropped. Tags are balanced, so the fragment cannot close or
+// leave open the elements around it: end tags of elements not open are
+// dropped, and elements still open at the end are closed. Uses
+// DefaultHtmlPolicy if policy is nil.
+func SanitizeHtml(str string, policy *HtmlPolicy) string {
+	if policy == nil {
+		policy = defaultHtmlPolicy
+	}
+
+	var sb strings.Builder
+
+	// NOTE: Allowed elements open in the fragment
+	openTags := make([]string, 0)
+
+	closeTags := func(count int) {
+		for range count {
+			sb.WriteString("</" + openTags[len(openTags)-1] + ">")
+			openTags = openTags[:len(openTags)-1]
+		}
+	}
+
+	walkHtml(str, func(text string) {
+		htmlEscaper.WriteString(&sb, text)
+	}, func(token html.Token) {
+		attrs, ok := policy.Tags[token.Data]
+		if !ok {
+			return
+		}
+
+		isVoid := slices.Contains(htmlVoidTags, token.Data)
+
+		if token.Type == html.EndTagToken {
+			// NOTE: Closes the innermost open element, elements opened inside
+			// it are closed with it
+			for i := len(openTags) - 1; i >= 0 && !isVoid; i-- {
+				if openTags[i] == token.Data {
+					closeTags(len(openTags) - i)
+
+					break
+				}
+			}
+
+			return
+		}
+
+		sb.WriteString("<" + token.Data)
+
+		for _, attr := range token.Attr {
+			if attr.Namespace != "" || !(slices.Contains(attrs, attr.Key) || slices.Contains(htmlGlobalAttrs, attr.Key)) {
+				continue
+			}
+
+			if attr.Key == "href" && !policy.allowsUrl(attr.Val) {
+				continue
+			}
+
+			sb.WriteString(" " + attr.Key + `="` + htmlEscaper.Replace(attr.Val) + `"`)
+		}
+
+		sb.WriteString(">")
+
+		switch {
+		case isVoid:
+		case token.Type == html.SelfClosingTagToken:
+			sb.WriteString("</" + token.Data + ">")
+		default:
+			openTags = append(openTags, token.Data)
+		}
+	})
+
+	closeTags(len(openTags))
+
+	return sb.String()
+}
+
+// NOTE: Tokenizes an HTML fragment, calls text for its text and tag for its
+// start, self-closing and end tags. Elements dropped with their content and
+// comments are skipped, tag may be nil.
+func walkHtml(str string, text func(text string), tag func(token html.Token)) {
+	tokenizer := html.NewTokenizer(strings.NewReader(str))
+
+	// NOTE: Depth inside elements dropped with their content
+	droppedDepth := 0
+
+	for {
+		tokenType := tokenizer.Next()
+
+		// NOTE: io.EOF is the only error of a strings.Reader
+		if tokenType == html.ErrorToken {
+			return
+		}
+
+		token := tokenizer.Token()
+
+		switch tokenType {
+		case html.TextToken:
+			if droppedDepth == 0 {
+				text(token.Data)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			isDropped := slices.Contains(htmlDroppedContentTags, token.Data)
+
+			switch {
+			case isDropped && tokenType == html.StartTagToken:
+				droppedDepth++
+			case isDropped && tokenType == html.EndTagToken:
+				droppedDepth = max(droppedDepth-1, 0)
+			case !isDropped && droppedDepth == 0 && tag != nil:
+				tag(token)
+			}
+		}
+	}
+}
+
+func (policy *HtmlPolicy) allowsUrl(rawUrl string) bool {
+	parsedUrl, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return false
+	}
+
+	// NOTE: Relative URLs and fragments
+	if parsedUrl.Scheme == "" {
+		return true
+	}
+
+	return slices.Contains(policy.UrlSchemes, strings.ToLower(parsedUrl.Scheme))
+}
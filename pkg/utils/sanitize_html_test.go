@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func TestSanitizeHtml(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		policy   *HtmlPolicy
+		expected string
+	}{
+		{
+			name:     "allowed tags are kept",
+			input:    `In the <i>beginning</i> <b class="x">God</b>`,
+			expected: `In the <i>beginning</i> <b class="x">God</b>`,
+		},
+		{
+			name:     "script is dropped with its content",
+			input:    `Jesus wept.<script>alert("x")</script>`,
+			expected: `Jesus wept.`,
+		},
+		{
+			name:     "unknown tags are dropped and their text is kept",
+			input:    `<div><font color="red">Amen</font></div>`,
+			expected: `Amen`,
+		},
+		{
+			name:     "event handler attributes are dropped",
+			input:    `<span onclick="alert(1)" class="name">David</span>`,
+			expected: `<span class="name">David</span>`,
+		},
+		{
+			name:     "javascript links are dropped",
+			input:    `<a href="javascript:alert(1)">x</a> <a href="https://example.com/?a=1&amp;b=2">y</a> <a href="#fn-1">z</a>`,
+			expected: `<a>x</a> <a href="https://example.com/?a=1&amp;b=2">y</a> <a href="#fn-1">z</a>`,
+		},
+		{
+			name:     "text is escaped",
+			input:    `1 &lt; 2 &amp; "quotes" and Lord's`,
+			expected: `1 &lt; 2 &amp; &quot;quotes&quot; and Lord's`,
+		},
+		{
+			name:     "comments are dropped",
+			input:    `a<!-- <b>b</b> -->c`,
+			expected: `ac`,
+		},
+		{
+			name:     "end tags of elements not open are dropped",
+			input:    `Amen</span></sup> <i>Amen</b></i>`,
+			expected: `Amen <i>Amen</i>`,
+		},
+		{
+			name:     "elements left open are closed",
+			input:    `<b>Blessed <i>are`,
+			expected: `<b>Blessed <i>are</i></b>`,
+		},
+		{
+			name:     "misnested elements are closed with their parent",
+			input:    `<b>Blessed <i>are</b> the meek</i>`,
+			expected: `<b>Blessed <i>are</i></b> the meek`,
+		},
+		{
+			name:     "void and self-closing elements",
+			input:    `a<br/>b<hr></hr><span/>c`,
+			expected: `a<br>b<hr><span></span>c`,
+		},
+		{
+			name:     "custom policy",
+			input:    `<b>bold</b> <i>italic</i>`,
+			policy:   &HtmlPolicy{Tags: map[string][]string{"i": {}}},
+			expected: `bold <i>italic</i>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := SanitizeHtml(tt.input, tt.policy)
+
+			if result != tt.expected {
+				t.Errorf("SanitizeHtml() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRenderVerses_UnbalancedHtml(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "MAT.5.3", Number: 3, Label: "3", Text: "Blessed are the poor</span></sup> in spirit.", ChapterId: "MAT.5"},
+		{Id: "MAT.5.4", Number: 4, Label: "4", Text: "Blessed are <b>those who mourn.", ChapterId: "MAT.5"},
+		{Id: "MAT.5.5", Number: 5, Label: "5", Text: "Blessed are the meek.", ChapterId: "MAT.5"},
+	}
+
+	expected := `<span id="v-MAT.5.3" data-verse="3" data-chapter="MAT.5"><sup><b>3</b></sup> Blessed are the poor in spirit.</span> ` +
+		`<span id="v-MAT.5.4" data-verse="4" data-chapter="MAT.5"><sup><b>4</b></sup> Blessed are <b>those who mourn.</b></span> ` +
+		`<span id="v-MAT.5.5" data-verse="5" data-chapter="MAT.5"><sup><b>5</b></sup> Blessed are the meek.</span><hr>` + "\n\n<ol></ol>"
+
+	result, err := RenderVerses(&HtmlRenderer{}, verses, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("RenderVerses() error = %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("RenderVerses() = %q, want %q", result, expected)
+	}
+}
+
+func TestProcessVerseHtml_SafeMode(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "JHN.11.35", Number: 35, Label: "35<img src=x>", Text: "Jesus <i>wept</i>.<script>alert(1)</script>", ChapterId: "JHN.11"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Content: `Or <a href="javascript:alert(1)">cried</a>`, Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 5, EndOffset: 5, TargetId: "JHN.11.35", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "JHN.11"},
+	}
+	headings := []*biblev1.Heading{
+		{Id: "h1", Text: `Lazarus<iframe src="x"></iframe>`, Level: 2, VerseId: "JHN.11.35", ChapterId: "JHN.11"},
+	}
+
+	expected := `<h2 id="h-h1">Lazarus</h2>
+<span id="v-JHN.11.35" data-verse="35" data-chapter="JHN.11"><sup><b>35&lt;img src=x&gt;</b></sup> Jesus<sup><a href="#fn-1-JHN.11" id="fnref-1-JHN.11">1</a></sup> <i>wept</i>.</span><hr>
+
+<ol><li id="fn-1-JHN.11"><p>Or <a>cried</a> [<a href="#fnref-1-JHN.11">1</a>]</p></li>
+
+</ol>`
+
+	result, err := ProcessVerseHtml(verses, marks, headings, nil)
+	if err != nil {
+		t.Fatalf("ProcessVerseHtml() error = %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("ProcessVerseHtml() = %q, want %q", result, expected)
+	}
+
+	unsafeResult, err := RenderVerses(&HtmlRenderer{Unsafe: true}, verses, marks, headings, nil, nil)
+	if err != nil {
+		t.Fatalf("RenderVerses() error = %v", err)
+	}
+
+	if !strings.Contains(unsafeResult, "<script>alert(1)</script>") {
+		t.Errorf("RenderVerses() with Unsafe = %q, want raw HTML kept", unsafeResult)
+	}
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"io"
+	"iter"
+	"strings"
+
+	"github.com/samber/lo"
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+// StreamVerses renders the verses yielded by verses with renderer and writes
+// the output to w chapter by chapter, so a whole book or testament is never
+// held in memory. Consecutive verses of a chapter are rendered together with
+// the marks and headings targeting them, and the psalm titles of their
+// ChapterId, like in RenderVerses.
+//
+// Footnotes are written after the verses of each chapter, unless
+// FootnotePlacementInline is used, so FootnotePlacementEndOfPassage behaves
+// like FootnotePlacementEndOfChapter. Footnotes of no yielded verse or heading
+// are written with the last chapter. Verses should be grouped by chapter.
+func StreamVerses(w io.Writer, renderer Renderer, verses iter.Seq[*biblev1.Verse], marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata, options *RenderOptions) error {
+	if options == nil {
+		options = &RenderOptions{}
+	}
+
+	showChapterBreaks := boolOption(options.ShowChapterBreaks, true)
+
+	marksByTarget := lo.GroupBy(marks, func(m *biblev1.Mark) streamMarkTarget {
+		return streamMarkTarget{targetType: m.TargetType, targetId: m.TargetId}
+	})
+	headingsByVerse := lo.GroupBy(headings, func(h *biblev1.Heading) string {
+		return h.VerseId
+	})
+	psalmsByChapter := lo.GroupBy(psalms, func(p *biblev1.PsalmMetadata) string {
+		return p.ChapterId
+	})
+
+	renderer = withIdPrefix(renderer, options.IdPrefix)
+	documentWriter := newDocumentWriter(renderer, options)
+	// NOTE: Footnote numbering continues across chapters
+	counters := make(map[footnoteCounterKey]int)
+
+	chapterVerses := make([]*biblev1.Verse, 0)
+	usedMarks := make(map[*biblev1.Mark]bool, len(marks))
+	written := false
+
+	// NOTE: Renders the verses of the current chapter and writes them to w
+	flush := func(isLast bool) error {
+		if len(chapterVerses) == 0 {
+			return nil
+		}
+
+		chapterId := chapterVerses[0].ChapterId
+		chapterMarks := make([]*biblev1.Mark, 0)
+		chapterHeadings := make([]*biblev1.Heading, 0)
+
+		for _, verse := range chapterVerses {
+			verseHeadings := headingsByVerse[verse.Id]
+			chapterHeadings = append(chapterHeadings, verseHeadings...)
+			chapterMarks = append(chapterMarks, marksByTarget[streamMarkTarget{targetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, targetId: verse.Id}]...)
+
+			for _, heading := range verseHeadings {
+				chapterMarks = append(chapterMarks, marksByTarget[streamMarkTarget{targetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_HEADING, targetId: heading.Id}]...)
+			}
+		}
+
+		for _, mark := range chapterMarks {
+			usedMarks[mark] = true
+		}
+
+		// NOTE: RenderVerses lists the footnotes of no rendered verse with
+		// those of the last chapter
+		if isLast {
+			chapterMarks = append(chapterMarks, lo.Filter(marks, func(mark *biblev1.Mark, _ int) bool {
+				return !usedMarks[mark]
+			})...)
+		}
+
+		document, err := buildDocument(chapterVerses, lo.Uniq(chapterMarks), lo.Uniq(chapterHeadings), psalmsByChapter[chapterId], options, counters)
+		if err != nil {
+			return err
+		}
+
+		chapterVerses = chapterVerses[:0]
+
+		var sb strings.Builder
+
+		for _, chapter := range document.Chapters {
+			documentWriter.writeChapter(&sb, chapter, documentNotes(document.Notes))
+		}
+
+		if options.FootnotePlacement != FootnotePlacementInline {
+			footnotes := lo.Uniq(lo.Map(documentWriter.footnotes(document.Notes), func(footnote footnoteEntry, _ int) string {
+				return footnote.entry
+			}))
+
+			if len(footnotes) > 0 {
+				sb.WriteString(renderer.FootnoteSection(footnotes))
+			}
+		}
+
+		output := renderer.Finalize(sb.String())
+
+		if output == "" {
+			return nil
+		}
+
+		// NOTE: Add line break between chapters
+		if written {
+			separator := "\n\n"
+
+			if showChapterBreaks {
+				separator = renderer.ChapterBreak()
+			}
+
+			if _, err := io.WriteString(w, separator); err != nil {
+				return err
+			}
+		}
+
+		written = true
+
+		_, err = io.WriteString(w, output)
+
+		return err
+	}
+
+	for verse := range verses {
+		if len(chapterVerses) > 0 && chapterVerses[0].ChapterId != verse.ChapterId {
+			if err := flush(false); err != nil {
+				return err
+			}
+		}
+
+		chapterVerses = append(chapterVerses, verse)
+	}
+
+	return flush(true)
+}
+
+// NOTE: Key of the marks of a verse or heading
+type streamMarkTarget struct {
+	targetType biblev1.MarkTargetType
+	targetId   string
+}
+
+// NOTE: options is variadic like ProcessVerseMd, only the first one is used
+func StreamVerseMd(w io.Writer, verses iter.Seq[*biblev1.Verse], marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata, options ...*RenderOptions) error {
+	return StreamVerses(w, &MdRenderer{}, verses, marks, headings, psalms, lo.FirstOrEmpty(options))
+}
+
+func StreamVerseHtml(w io.Writer, verses iter.Seq[*biblev1.Verse], marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata, options ...*RenderOptions) error {
+	return StreamVerses(w, &HtmlRenderer{}, verses, marks, headings, psalms, lo.FirstOrEmpty(options))
+}
+
+func StreamVerseText(w io.Writer, verses iter.Seq[*biblev1.Verse], marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata, options ...*RenderOptions) error {
+	return StreamVerses(w, &TextRenderer{}, verses, marks, headings, psalms, lo.FirstOrEmpty(options))
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func TestStreamVerses(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "JHN.1.1", Number: 1, Label: "1", Text: "In the beginning was the Word.", ChapterId: "JHN.1"},
+		{Id: "JHN.1.2", Number: 2, Label: "2", Text: "He was with God.", ChapterId: "JHN.1"},
+		{Id: "JHN.2.1", Number: 1, Label: "1", Text: "On the third day.", ChapterId: "JHN.2"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Content: "Or *Logos*", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 29, EndOffset: 29, TargetId: "JHN.1.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "JHN.1"},
+		{Id: "ref1", Content: "Gn 1:1", Kind: biblev1.MarkKind_MARK_KIND_REFERENCE, StartOffset: 16, EndOffset: 16, TargetId: "JHN.2.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "JHN.2"},
+	}
+	headings := []*biblev1.Heading{
+		{Id: "h1", Text: "The Wedding at Cana", Level: 2, VerseId: "JHN.2.1", ChapterId: "JHN.2"},
+	}
+
+	tests := []struct {
+		name     string
+		renderer Renderer
+		options  *RenderOptions
+		expected string
+	}{
+		{
+			name:     "markdown",
+			renderer: &MdRenderer{},
+			expected: "<sup><b>1</b></sup> In the beginning was the Word[^1-JHN.1]. <sup><b>2</b></sup> He was with God.\n\n" +
+				"[^1-JHN.1]: Or *Logos*\n\n" +
+				"---\n\n" +
+				"## The Wedding at Cana\n<sup><b>1</b></sup> On the third day[^1@-JHN.2].\n\n" +
+				"[^1@-JHN.2]: Gn 1:1",
+		},
+		{
+			name:     "html without chapter breaks",
+			renderer: &HtmlRenderer{},
+			options:  &RenderOptions{ShowHeadings: boolPtr(false), ShowReferences: boolPtr(false), ShowChapterBreaks: boolPtr(false)},
+			expected: `<span id="v-JHN.1.1" data-verse="1" data-chapter="JHN.1"><sup><b>1</b></sup> In the beginning was the Word<sup><a href="#fn-1-JHN.1" id="fnref-1-JHN.1">1</a></sup>.</span> <span id="v-JHN.1.2" data-verse="2" data-chapter="JHN.1"><sup><b>2</b></sup> He was with God.</span><hr>` + "\n\n" +
+				`<ol><li id="fn-1-JHN.1"><p>Or <em>Logos</em> [<a href="#fnref-1-JHN.1">1</a>]</p></li>` + "\n\n</ol>\n\n" +
+				`<span id="v-JHN.2.1" data-verse="1" data-chapter="JHN.2"><sup><b>1</b></sup> On the third day.</span>`,
+		},
+		{
+			name:     "inline footnotes",
+			renderer: &TextRenderer{},
+			options:  &RenderOptions{ShowVerseNumbers: boolPtr(false), FootnotePlacement: FootnotePlacementInline},
+			expected: "In the beginning was the Word (Or Logos). He was with God.\n\n" +
+				"The Wedding at Cana\nOn the third day (Gn 1:1).",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sb strings.Builder
+
+			if err := StreamVerses(&sb, tt.renderer, slices.Values(verses), marks, headings, nil, tt.options); err != nil {
+				t.Fatalf("StreamVerses() error = %v", err)
+			}
+
+			if sb.String() != tt.expected {
+				t.Errorf("StreamVerses() = %q, want %q", sb.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestStreamVerses_ProcessVerse(t *testing.T) {
+	_, _, verses, marks, headings, psalms := usfmTestData()
+
+	type streamFunc func(w io.Writer, verses iter.Seq[*biblev1.Verse], marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata, options ...*RenderOptions) error
+	type processFunc func(verses []*biblev1.Verse, marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata, options ...*RenderOptions) (string, error)
+
+	formats := []struct {
+		name    string
+		stream  streamFunc
+		process processFunc
+	}{
+		{name: "md", stream: StreamVerseMd, process: ProcessVerseMd},
+		{name: "html", stream: StreamVerseHtml, process: ProcessVerseHtml},
+		{name: "text", stream: StreamVerseText, process: ProcessVerseText},
+	}
+
+	tests := []struct {
+		name     string
+		verses   []*biblev1.Verse
+		marks    []*biblev1.Mark
+		headings []*biblev1.Heading
+		psalms   []*biblev1.PsalmMetadata
+	}{
+		{
+			name:     "chapters with marks, headings and psalm titles",
+			verses:   verses,
+			marks:    marks,
+			headings: headings,
+			psalms:   psalms,
+		},
+		{
+			// NOTE: Marks and headings are matched by their target, not their
+			// ChapterId
+			name: "marks and headings without chapter id",
+			verses: []*biblev1.Verse{
+				{Id: "v1", Number: 1, Label: "1", Text: "Text.", ChapterId: "c1"},
+			},
+			marks: []*biblev1.Mark{
+				{Id: "fn1", Content: "note", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 4, EndOffset: 4, TargetId: "v1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE},
+			},
+			headings: []*biblev1.Heading{
+				{Id: "h1", Text: "Head", Level: 1, VerseId: "v1"},
+			},
+		},
+		{
+			name:   "footnotes of no verse are written with the last chapter",
+			verses: verses,
+			marks: append(slices.Clone(marks),
+				&biblev1.Mark{Id: "fn9", Content: "Elsewhere", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, SortOrder: 8, TargetId: "PSA.22.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.22"},
+			),
+			headings: headings,
+			psalms:   psalms,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, format := range formats {
+			for _, placement := range []FootnotePlacement{FootnotePlacementEndOfChapter, FootnotePlacementInline} {
+				options := &RenderOptions{FootnotePlacement: placement}
+
+				expected, err := format.process(tt.verses, tt.marks, tt.headings, tt.psalms, options)
+				if err != nil {
+					t.Fatalf("ProcessVerse() error = %v", err)
+				}
+
+				var sb strings.Builder
+
+				if err := format.stream(&sb, slices.Values(tt.verses), tt.marks, tt.headings, tt.psalms, options); err != nil {
+					t.Fatalf("StreamVerses() error = %v", err)
+				}
+
+				if sb.String() != expected {
+					t.Errorf("%s: %s placement %d: StreamVerses() = %q, want %q", tt.name, format.name, placement, sb.String(), expected)
+				}
+			}
+		}
+	}
+}
+
+type failingWriter struct{}
+
+var errFailingWriter = errors.New("write failed")
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errFailingWriter
+}
+
+func TestStreamVerses_WriterError(t *testing.T) {
+	_, _, verses, marks, headings, psalms := usfmTestData()
+
+	if err := StreamVerseMd(failingWriter{}, slices.Values(verses), marks, headings, psalms); !errors.Is(err, errFailingWriter) {
+		t.Errorf("StreamVerseMd() error = %v, want %v", err, errFailingWriter)
+	}
+}
+
+func BenchmarkStreamVerseMd_Book(b *testing.B) {
+	verses, marks, headings, psalms := benchmarkBook()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := StreamVerseMd(io.Discard, slices.Values(verses), marks, headings, psalms); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(verses)), "ns/verse")
+}
+
+func BenchmarkStreamVerseHtml_Book(b *testing.B) {
+	verses, marks, headings, psalms := benchmarkBook()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := StreamVerseHtml(io.Discard, slices.Values(verses), marks, headings, psalms); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(verses)), "ns/verse")
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+// TextRenderer renders verses to plain text, e.g. for notifications, search
+// snippets or the clipboard. Markup is dropped, footnotes are rendered as "[1]"
+// with a numbered notes list at the end.
+type TextRenderer struct{}
+
+var _ Renderer = (*TextRenderer)(nil)
+
+var unspecifiedTextLabel = func(mark *biblev1.Mark, chapterId string) string {
+	return ""
+}
+
+var fnTextLabel = func(mark *biblev1.Mark, chapterId string) string {
+	return fmt.Sprintf("[%s]", footnoteLabel(mark))
+}
+
+var refTextLabel = func(mark *biblev1.Mark, chapterId string) string {
+	return fmt.Sprintf("[%s@]", footnoteLabel(mark))
+}
+
+var wojTextLabel = func(mark *biblev1.Mark, chapterId string) string {
+	return mark.Content
+}
+
+// NOTE: Converts Markdown text to plain text, through HTML so entities and
+// inline raw HTML are handled like in HtmlRenderer
+func mdToText(md string) string {
+	var sb strings.Builder
+
+	walkHtml(mdToHTML(md), func(text string) {
+		sb.WriteString(text)
+	}, nil)
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (r *TextRenderer) Inline(text string, marks []*biblev1.Mark, labelMap map[biblev1.MarkKind]MarkLabelFunc) string {
+	content, contentMarks := convertWithMarks(text, marks, mdToText)
+
+	return InjectMarkLabel(content, contentMarks, labelMap)
+}
+
+func (r *TextRenderer) MarkLabels() map[biblev1.MarkKind]MarkLabelFunc {
+	return map[biblev1.MarkKind]MarkLabelFunc{
+		biblev1.MarkKind_MARK_KIND_UNSPECIFIED:    unspecifiedTextLabel,
+		biblev1.MarkKind_MARK_KIND_FOOTNOTE:       fnTextLabel,
+		biblev1.MarkKind_MARK_KIND_REFERENCE:      refTextLabel,
+		biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: wojTextLabel,
+	}
+}
+
+func (r *TextRenderer) VerseNumber(verse *biblev1.Verse) string {
+	return fmt.Sprintf("[%s]", verse.Label)
+}
+
+// NOTE: Each poetry verse is on its own line, the "\n \n" between two poetry
+// verses is removed in Finalize
+func (r *TextRenderer) Poetry(content string) string {
+	return "\n" + content + "\n"
+}
+
+func (r *TextRenderer) PsalmTitle(psalm *biblev1.PsalmMetadata) string {
+	return mdToText(psalm.Text)
+}
+
+func (r *TextRenderer) Heading(heading *biblev1.Heading, content string) string {
+	return "\n" + content + "\n"
+}
+
+func (r *TextRenderer) ChapterBreak() string {
+	return "\n\n"
+}
+
+func (r *TextRenderer) Footnote(mark *biblev1.Mark) string {
+	switch mark.Kind {
+	case biblev1.MarkKind_MARK_KIND_FOOTNOTE:
+		return fmt.Sprintf("[%s] %s", footnoteLabel(mark), mdToText(mark.Content))
+	case biblev1.MarkKind_MARK_KIND_REFERENCE:
+		return fmt.Sprintf("[%s@] %s", footnoteLabel(mark), mdToText(mark.Content))
+	default:
+		return ""
+	}
+}
+
+func (r *TextRenderer) InlineFootnote(mark *biblev1.Mark) string {
+	return fmt.Sprintf(" (%s)", mdToText(mark.Content))
+}
+
+func (r *TextRenderer) FootnoteSection(footnotes []string) string {
+	return "\n\n" + strings.Join(footnotes, "\n")
+}
+
+func (r *TextRenderer) Finalize(output string) string {
+	// NOTE: Keep consecutive poetry verses on consecutive lines
+	output = strings.ReplaceAll(output, "\n \n", "\n")
+
+	lines := lo.Map(strings.Split(output, "\n"), func(line string, _ int) string {
+		return strings.TrimSpace(line)
+	})
+
+	output = strings.Join(lines, "\n")
+	// NOTE: Clean up the redundant newlines
+	output = redundantNewlinesRegex.ReplaceAllString(output, "\n\n")
+
+	return strings.TrimSpace(output)
+}
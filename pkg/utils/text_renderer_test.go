@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func TestProcessVerseText(t *testing.T) {
+	verses := []*biblev1.Verse{
+		{Id: "PSA.23.1", Label: "1", Text: "The Lord is my *shepherd*; I shall not want.", IsPoetry: true, ChapterId: "PSA.23"},
+		{Id: "PSA.23.2", Label: "2", Text: "He makes me lie down in green pastures.", IsPoetry: true, ChapterId: "PSA.23"},
+		{Id: "PSA.23.3", Label: "3", Text: "He restores my soul & leads me.", ParagraphNumber: 1, ChapterId: "PSA.23"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Content: "Or *keeper*", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 25, EndOffset: 25, TargetId: "PSA.23.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+		{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 8, TargetId: "PSA.23.2", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+	}
+	headings := []*biblev1.Heading{
+		{Id: "h1", Text: "The **Good** Shepherd", Level: 1, VerseId: "PSA.23.1", ChapterId: "PSA.23"},
+	}
+
+	tests := []struct {
+		name     string
+		options  *RenderOptions
+		expected string
+	}{
+		{
+			name: "default",
+			expected: "The Good Shepherd\n\n" +
+				"[1] The Lord is my shepherd[1]; I shall not want.\n" +
+				"[2] He makes me lie down in green pastures.\n\n" +
+				"[3] He restores my soul & leads me.\n\n" +
+				"[1] Or keeper",
+		},
+		{
+			name:    "without verse numbers",
+			options: &RenderOptions{ShowVerseNumbers: boolPtr(false)},
+			expected: "The Good Shepherd\n\n" +
+				"The Lord is my shepherd[1]; I shall not want.\n" +
+				"He makes me lie down in green pastures.\n\n" +
+				"He restores my soul & leads me.\n\n" +
+				"[1] Or keeper",
+		},
+		{
+			name:    "inline footnotes",
+			options: &RenderOptions{ShowHeadings: boolPtr(false), FootnotePlacement: FootnotePlacementInline},
+			expected: "[1] The Lord is my shepherd (Or keeper); I shall not want.\n" +
+				"[2] He makes me lie down in green pastures.\n\n" +
+				"[3] He restores my soul & leads me.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ProcessVerseText(verses, marks, headings, nil, tt.options)
+			if err != nil {
+				t.Fatalf("ProcessVerseText() error = %v", err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("ProcessVerseText() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
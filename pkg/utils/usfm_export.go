@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+var ErrUsfmChapterNotFound = errors.New("usfm chapter not found")
+
+// NOTE: USFM supports section headings from \s1 to \s3 only
+const maxUsfmHeading = 3
+
+var usfmMarkLabels = map[biblev1.MarkKind]MarkLabelFunc{
+	biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`\f + \ft %s\f*`, usfmText(mark.Content))
+	},
+	biblev1.MarkKind_MARK_KIND_REFERENCE: func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`\x - \xt %s\x*`, usfmText(mark.Content))
+	},
+	biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`\wj %s\wj*`, mark.Content)
+	},
+}
+
+// NOTE: Converts Markdown text to USFM text. USFM has no escaping, so
+// backslashes, which start markers, are replaced by slashes
+func usfmText(md string) string {
+	return strings.ReplaceAll(mdToText(md), `\`, "/")
+}
+
+// NOTE: Renders text converted by convert with the labels of marks, for the
+// export functions. Notes are placed after the text they refer to.
+func exportInline(text string, marks []*biblev1.Mark, convert func(string) string, labelMap map[biblev1.MarkKind]MarkLabelFunc) string {
+	anchors := lo.Map(marks, func(mark *biblev1.Mark, _ int) *biblev1.Mark {
+		if mark.Kind != biblev1.MarkKind_MARK_KIND_FOOTNOTE && mark.Kind != biblev1.MarkKind_MARK_KIND_REFERENCE {
+			return mark
+		}
+
+		// NOTE: Content keeps the note body
+		newMark := cloneMark(mark)
+		newMark.StartOffset = newMark.EndOffset
+
+		return newMark
+	})
+
+	content, contentMarks := convertWithMarks(text, anchors, convert)
+
+	return InjectMarkLabel(content, contentMarks, labelMap)
+}
+
+// ExportUsfm exports a book to USFM. chapters provides the chapter numbers of
+// the \c markers, verses must be in reading order. book may be nil to omit
+// the \id and \h markers.
+func ExportUsfm(book *biblev1.Book, chapters []*biblev1.Chapter, verses []*biblev1.Verse, marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata) (string, error) {
+	chapterNumbers := make(map[string]int32, len(chapters))
+
+	for _, chapter := range chapters {
+		chapterNumbers[chapter.Id] = chapter.Number
+	}
+
+	markIndex := NewMarkIndex(marks)
+	headingsByVerse := lo.GroupBy(headings, func(h *biblev1.Heading) string {
+		return h.VerseId
+	})
+	psalmsByChapter := lo.GroupBy(psalms, func(p *biblev1.PsalmMetadata) string {
+		return p.ChapterId
+	})
+
+	lines := make([]string, 0, len(verses)+len(headings)+2)
+
+	if book != nil {
+		lines = append(lines, `\id `+strings.ToUpper(book.Code))
+
+		if book.Name != "" {
+			lines = append(lines, `\h `+usfmText(book.Name))
+		}
+	}
+
+	currentChapterId := ""
+	currPar := int32(-1)
+	prevIsPoetry := false
+	// NOTE: Whether the last line holds verse text, sub-verses can only be
+	// appended to it
+	prevIsVerseLine := false
+
+	for _, verse := range verses {
+		newChapter := verse.ChapterId != currentChapterId
+
+		if newChapter {
+			chapterNumber, ok := chapterNumbers[verse.ChapterId]
+			if !ok {
+				return "", fmt.Errorf("%w: %s", ErrUsfmChapterNotFound, verse.ChapterId)
+			}
+
+			lines = append(lines, fmt.Sprintf(`\c %d`, chapterNumber))
+
+			currentChapterId = verse.ChapterId
+			currPar = -1
+			prevIsPoetry = false
+			prevIsVerseLine = false
+		}
+
+		for _, heading := range headingsByVerse[verse.Id] {
+			headingMarks := markIndex.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_HEADING, heading.Id, biblev1.MarkKind_MARK_KIND_FOOTNOTE, biblev1.MarkKind_MARK_KIND_REFERENCE)
+
+			level := min(max(int(heading.Level), 1), maxUsfmHeading)
+
+			lines = append(lines, fmt.Sprintf(`\s%d %s`, level, exportInline(heading.Text, headingMarks, usfmText, usfmMarkLabels)))
+			prevIsVerseLine = false
+		}
+
+		if newChapter {
+			for _, psalm := range psalmsByChapter[verse.ChapterId] {
+				lines = append(lines, `\d `+usfmText(psalm.Text))
+				prevIsVerseLine = false
+			}
+		}
+
+		verseMarks := markIndex.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, verse.Id, biblev1.MarkKind_MARK_KIND_FOOTNOTE, biblev1.MarkKind_MARK_KIND_REFERENCE, biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS)
+
+		content := exportInline(verse.Text, verseMarks, usfmText, usfmMarkLabels)
+
+		// NOTE: Sub-verses continue the verse of the previous \v marker
+		if verse.SubVerseIndex == 0 {
+			content = fmt.Sprintf(`\v %d %s`, verse.Number, content)
+		}
+
+		newParagraph := verse.ParagraphNumber != currPar
+
+		switch {
+		case verse.IsPoetry:
+			// NOTE: Each poetry verse is one line of poetry
+			lines = append(lines, `\q1 `+content)
+		case newParagraph:
+			lines = append(lines, `\p`, content)
+		case prevIsPoetry, verse.SubVerseIndex > 0 && !prevIsVerseLine:
+			// NOTE: Prose continuing the paragraph after poetry, or a sub-verse
+			// after a heading
+			lines = append(lines, `\m`, content)
+		case verse.SubVerseIndex > 0:
+			lines[len(lines)-1] += " " + content
+		default:
+			lines = append(lines, content)
+		}
+
+		currPar = verse.ParagraphNumber
+		prevIsPoetry = verse.IsPoetry
+		prevIsVerseLine = true
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func usfmTestData() (*biblev1.Book, []*biblev1.Chapter, []*biblev1.Verse, []*biblev1.Mark, []*biblev1.Heading, []*biblev1.PsalmMetadata) {
+	book := &biblev1.Book{Id: "psa", Code: "psa", Name: "Psalms"}
+	chapters := []*biblev1.Chapter{
+		{Id: "PSA.23", Number: 23, BookId: "psa"},
+		{Id: "PSA.24", Number: 24, BookId: "psa"},
+	}
+	verses := []*biblev1.Verse{
+		{Id: "PSA.23.1", Number: 1, Label: "1", Text: "The Lord is my *shepherd*;", IsPoetry: true, ChapterId: "PSA.23"},
+		{Id: "PSA.23.1b", Number: 1, Label: "1b", Text: "I shall not want.", SubVerseIndex: 1, IsPoetry: true, ChapterId: "PSA.23"},
+		{Id: "PSA.23.2", Number: 2, Label: "2", Text: "He said, Follow me.", ChapterId: "PSA.23"},
+		{Id: "PSA.23.3", Number: 3, Label: "3", Text: "He restores my soul.", ParagraphNumber: 1, ChapterId: "PSA.23"},
+		{Id: "PSA.24.1", Number: 1, Label: "1", Text: "The earth is the Lord's.", ChapterId: "PSA.24"},
+	}
+	marks := []*biblev1.Mark{
+		{Id: "fn1", Content: "Or *keeper*", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 25, EndOffset: 25, TargetId: "PSA.23.1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+		{Id: "woj1", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 9, EndOffset: 18, TargetId: "PSA.23.2", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+		{Id: "ref1", Content: "Mt 4:19", Kind: biblev1.MarkKind_MARK_KIND_REFERENCE, StartOffset: 15, EndOffset: 15, TargetId: "PSA.23.2", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, ChapterId: "PSA.23"},
+		{Id: "fn2", Content: "Or life", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 8, EndOffset: 8, TargetId: "h1", TargetType: biblev1.MarkTargetType_MARK_TARGET_TYPE_HEADING, ChapterId: "PSA.23"},
+	}
+	headings := []*biblev1.Heading{
+		{Id: "h1", Text: "The Good Shepherd", Level: 1, VerseId: "PSA.23.1", ChapterId: "PSA.23"},
+		{Id: "h2", Text: "The King of Glory", Level: 5, VerseId: "PSA.24.1", ChapterId: "PSA.24"},
+	}
+	psalms := []*biblev1.PsalmMetadata{
+		{Id: "p1", Text: "A Psalm of David.", ChapterId: "PSA.23"},
+	}
+
+	return book, chapters, verses, marks, headings, psalms
+}
+
+func TestExportUsfm(t *testing.T) {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+
+	expected := `\id PSA
+\h Psalms
+\c 23
+\s1 The Good\f + \ft Or life\f* Shepherd
+\d A Psalm of David.
+\q1 \v 1 The Lord is my shepherd\f + \ft Or keeper\f*;
+\q1 I shall not want.
+\m
+\v 2 He said, \wj Follow\x - \xt Mt 4:19\x* me\wj*.
+\p
+\v 3 He restores my soul.
+\c 24
+\s3 The King of Glory
+\p
+\v 1 The earth is the Lord's.
+`
+
+	result, err := ExportUsfm(book, chapters, verses, marks, headings, psalms)
+	if err != nil {
+		t.Fatalf("ExportUsfm() error = %v", err)
+	}
+
+	if result != expected {
+		t.Errorf("ExportUsfm() = %q, want %q", result, expected)
+	}
+}
+
+func TestExportUsfm_ChapterNotFound(t *testing.T) {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+
+	_, err := ExportUsfm(book, chapters[:1], verses, marks, headings, psalms)
+	if !errors.Is(err, ErrUsfmChapterNotFound) {
+		t.Errorf("ExportUsfm() error = %v, want %v", err, ErrUsfmChapterNotFound)
+	}
+}
@@ -0,0 +1,397 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+var ErrUsfmMissingBookId = errors.New("usfm book id not found")
+
+// UsfmBook is a book imported from USFM.
+//
+// Deprecated: Use BookData, shared by every importer.
+type UsfmBook = BookData
+
+// UsfmDiagnostic reports USFM content that ImportUsfm could not import as-is.
+//
+// Deprecated: Use ImportDiagnostic, shared by every importer.
+type UsfmDiagnostic = ImportDiagnostic
+
+var usfmMarkerRegexp = regexp.MustCompile(`\\\+?([A-Za-z0-9]+)(\*?)`)
+
+// NOTE: Header markers, their text is dropped silently
+var usfmHeaderMarkers = []string{"ide", "usfm", "rem", "sts", "toc", "toca", "mt", "mte"}
+
+// NOTE: Markers with content this SDK has no structure for, their text is
+// dropped with a diagnostic
+var usfmIgnoredMarkers = []string{
+	"ms", "mr", "r", "sr", "sp", "sd", "cl", "cd", "cp", "ca", "va", "vp",
+	"imt", "is", "ip", "ipi", "im", "imi", "ipq", "imq", "ipr", "iq", "ib",
+	"ili", "iot", "io", "iex", "imte", "ie",
+}
+
+var usfmParagraphMarkers = []string{"p", "pi", "pmo", "pm", "pmc", "pmr", "pc", "pr", "cls", "li", "ph", "lh", "lf", "b"}
+
+var usfmContinuationMarkers = []string{"m", "mi", "nb"}
+
+var usfmPoetryMarkers = []string{"q", "qr", "qc", "qm", "qa"}
+
+// NOTE: Character markers whose text is kept without formatting
+var usfmCharacterMarkers = []string{
+	"add", "bk", "dc", "k", "nd", "ord", "pn", "png", "addpn", "qt", "sig",
+	"sls", "tl", "w", "rq", "em", "bd", "it", "bdit", "no", "sc", "sup", "qs",
+	"qac", "lit", "jmp", "wg", "wh", "wa", "ior", "iqt",
+}
+
+// NOTE: Note content markers whose text is kept in the footnote body
+var usfmNoteTextMarkers = []string{"ft", "fk", "fq", "fqa", "fl", "fw", "fp", "xt", "xk", "xq", "xta"}
+
+type usfmToken struct {
+	// NOTE: Marker name without the backslash and the "+" prefix of nested
+	// markers, empty for text
+	marker  string
+	closing bool
+	text    string
+	line    int
+}
+
+func tokenizeUsfm(usfm string) []usfmToken {
+	tokens := make([]usfmToken, 0)
+
+	line := 1
+	pos := 0
+
+	for _, loc := range usfmMarkerRegexp.FindAllStringSubmatchIndex(usfm, -1) {
+		if loc[0] < pos {
+			continue
+		}
+
+		if loc[0] > pos {
+			text := usfm[pos:loc[0]]
+
+			tokens = append(tokens, usfmToken{text: text, line: line})
+			line += strings.Count(text, "\n")
+		}
+
+		closing := loc[5] > loc[4]
+
+		tokens = append(tokens, usfmToken{marker: usfm[loc[2]:loc[3]], closing: closing, line: line})
+
+		pos = loc[1]
+
+		// NOTE: One whitespace after an opening marker is part of the marker
+		if !closing && pos < len(usfm) && strings.ContainsRune(" \t\r\n", rune(usfm[pos])) {
+			if usfm[pos] == '\r' && pos+1 < len(usfm) && usfm[pos+1] == '\n' {
+				pos++
+			}
+
+			if usfm[pos] == '\n' {
+				line++
+			}
+
+			pos++
+		}
+	}
+
+	if pos < len(usfm) {
+		tokens = append(tokens, usfmToken{text: usfm[pos:], line: line})
+	}
+
+	return tokens
+}
+
+// NOTE: Splits a marker into its name and level, e.g. "q2" into "q" and 2.
+// The level is 0 if the marker has no number.
+func splitUsfmMarker(marker string) (string, int) {
+	name := strings.TrimRight(marker, "0123456789")
+	level, _ := strconv.Atoi(marker[len(name):])
+
+	return name, level
+}
+
+// NOTE: Text read by the parser itself instead of the book builder
+type usfmField int
+
+const (
+	usfmFieldNone usfmField = iota
+	usfmFieldBookId
+	usfmFieldBookName
+	usfmFieldChapterNumber
+	usfmFieldVerseNumber
+)
+
+type usfmParser struct {
+	book  *bookBuilder
+	name  textBuffer
+	field usfmField
+	// NOTE: Reading USX, whose text has no "~", "|" attributes or note
+	// callers
+	usx bool
+
+	// NOTE: Marker of the open note, whether its caller was read and whether
+	// its text is kept
+	noteMarker     string
+	noteCallerRead bool
+	noteKeep       bool
+	// NOTE: Character markers open, and whether the attributes after "|" of
+	// the innermost one are being dropped
+	charDepth      int
+	dropAttributes bool
+}
+
+// ImportUsfm parses a USFM book into verses, headings, marks and psalm
+// titles. Mark offsets are rune offsets in the verse text, after footnotes and
+// character markers are stripped. Markers that cannot be imported are
+// reported in Diagnostics.
+func ImportUsfm(usfm string) (*BookData, error) {
+	p := &usfmParser{book: newBookBuilder()}
+
+	for _, token := range tokenizeUsfm(usfm) {
+		p.book.line = token.line
+		p.book.marker = token.marker
+
+		switch {
+		case token.marker == "":
+			p.text(token.text)
+		case token.closing:
+			p.closeMarker(token.marker)
+		default:
+			p.openMarker(token.marker)
+		}
+	}
+
+	if p.book.code == "" {
+		return nil, ErrUsfmMissingBookId
+	}
+
+	return p.book.finish(p.name.String()), nil
+}
+
+func (p *usfmParser) diagnose(marker, message string) {
+	p.book.marker = marker
+	p.book.diagnose(message)
+}
+
+func (p *usfmParser) text(text string) {
+	if !p.usx {
+		// NOTE: "~" is a no-break space in USFM
+		text = strings.ReplaceAll(text, "~", "\u00A0")
+	}
+
+	if p.book.note != nil {
+		if !p.noteCallerRead && !p.usx {
+			text = strings.TrimLeft(text, " \t\r\n")
+			if text == "" {
+				return
+			}
+
+			// NOTE: The first character is the note caller, e.g. "+"
+			_, callerSize := utf8.DecodeRuneInString(text)
+			text = text[callerSize:]
+			p.noteCallerRead = true
+		}
+
+		if p.noteKeep {
+			p.book.write(text)
+		}
+
+		return
+	}
+
+	if p.charDepth > 0 {
+		if p.dropAttributes {
+			return
+		}
+
+		if idx := strings.IndexRune(text, '|'); idx != -1 && !p.usx {
+			text = text[:idx]
+			p.dropAttributes = true
+		}
+	}
+
+	switch p.field {
+	case usfmFieldBookId:
+		if fields := strings.Fields(text); len(fields) > 0 && p.book.code == "" {
+			p.book.code = fields[0]
+		}
+
+		p.field = usfmFieldNone
+	case usfmFieldBookName:
+		p.name.write(text)
+	case usfmFieldChapterNumber:
+		p.field = usfmFieldNone
+		p.startChapter(text)
+	case usfmFieldVerseNumber:
+		p.field = usfmFieldNone
+		p.startVerse(text)
+	default:
+		p.book.write(text)
+	}
+}
+
+// NOTE: Resets the state of the previous paragraph or block marker
+func (p *usfmParser) startBlock(field usfmField) {
+	p.field = field
+	p.charDepth = 0
+	p.dropAttributes = false
+}
+
+func (p *usfmParser) openMarker(marker string) {
+	name, level := splitUsfmMarker(marker)
+
+	if p.book.note != nil {
+		switch {
+		case slices.Contains(usfmNoteTextMarkers, marker):
+			p.noteKeep = true
+			p.noteCallerRead = true
+
+			return
+		case strings.HasPrefix(marker, "f") || strings.HasPrefix(marker, "x"):
+			// NOTE: Other note markers, e.g. the origin reference \fr
+			p.noteKeep = false
+			p.noteCallerRead = true
+
+			return
+		case slices.Contains(usfmCharacterMarkers, name):
+			return
+		}
+
+		p.diagnose(marker, fmt.Sprintf("unclosed note \\%s", p.noteMarker))
+		p.book.closeNote()
+	}
+
+	switch {
+	case marker == "id":
+		p.startBlock(usfmFieldBookId)
+		p.book.ignore()
+	case marker == "h":
+		p.startBlock(usfmFieldBookName)
+		p.book.ignore()
+	case slices.Contains(usfmHeaderMarkers, name):
+		p.startBlock(usfmFieldNone)
+		p.book.ignore()
+	case marker == "c":
+		p.startBlock(usfmFieldChapterNumber)
+		p.book.finishBlock()
+	case marker == "v":
+		p.startBlock(usfmFieldVerseNumber)
+		p.book.finishBlock()
+	case name == "s":
+		p.startBlock(usfmFieldNone)
+		p.book.startHeading(min(max(level, 1), maxUsfmHeading))
+	case marker == "d":
+		p.startBlock(usfmFieldNone)
+		p.book.startPsalm()
+	case slices.Contains(usfmParagraphMarkers, name):
+		p.startBlock(usfmFieldNone)
+		p.book.startParagraph(name == "b" && p.book.isPoetry)
+	case slices.Contains(usfmContinuationMarkers, name):
+		p.startBlock(usfmFieldNone)
+		p.book.continueParagraph(false)
+	case slices.Contains(usfmPoetryMarkers, name):
+		p.startBlock(usfmFieldNone)
+		p.book.continueParagraph(true)
+	case marker == "f" || marker == "fe" || marker == "ef":
+		p.openNote(marker, biblev1.MarkKind_MARK_KIND_FOOTNOTE)
+	case marker == "x" || marker == "ex":
+		p.openNote(marker, biblev1.MarkKind_MARK_KIND_REFERENCE)
+	case marker == "wj":
+		p.charDepth++
+		p.dropAttributes = false
+		p.book.openWordsOfJesus()
+	case slices.Contains(usfmCharacterMarkers, name):
+		p.charDepth++
+		p.dropAttributes = false
+	case marker == "fig":
+		// NOTE: Figures are dropped with their caption
+		p.diagnose(marker, "unsupported marker \\fig, its content is dropped")
+		p.charDepth++
+		p.dropAttributes = true
+	case slices.Contains(usfmIgnoredMarkers, name):
+		p.startBlock(usfmFieldNone)
+		p.book.ignore()
+		p.diagnose(marker, fmt.Sprintf("unsupported marker \\%s, its content is dropped", marker))
+	default:
+		p.diagnose(marker, fmt.Sprintf("unsupported marker \\%s, its content is kept as text", marker))
+	}
+}
+
+func (p *usfmParser) closeMarker(marker string) {
+	name, _ := splitUsfmMarker(marker)
+
+	if p.book.note != nil {
+		if marker == p.noteMarker {
+			p.book.closeNote()
+
+			return
+		}
+
+		// NOTE: Closing an inner note marker, e.g. \fq*, goes back to the
+		// note text
+		p.noteKeep = true
+
+		return
+	}
+
+	switch {
+	case marker == "wj":
+		p.charDepth = max(p.charDepth-1, 0)
+		p.dropAttributes = false
+		p.book.closeWordsOfJesus()
+	case slices.Contains(usfmCharacterMarkers, name) || marker == "fig":
+		p.charDepth = max(p.charDepth-1, 0)
+		p.dropAttributes = false
+	default:
+		p.diagnose(marker, fmt.Sprintf("unsupported closing marker \\%s*", marker))
+	}
+}
+
+func (p *usfmParser) openNote(marker string, kind biblev1.MarkKind) {
+	p.noteMarker = marker
+	p.noteCallerRead = false
+	p.noteKeep = true
+
+	p.book.openNote(kind)
+}
+
+func (p *usfmParser) startChapter(text string) {
+	p.book.marker = "c"
+
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		p.book.diagnose("missing chapter number")
+
+		return
+	}
+
+	number, err := strconv.Atoi(fields[0])
+	if err != nil {
+		p.book.diagnose(fmt.Sprintf("invalid chapter number %q", fields[0]))
+
+		return
+	}
+
+	p.book.startChapter(number)
+}
+
+func (p *usfmParser) startVerse(text string) {
+	p.book.marker = "v"
+
+	text = strings.TrimLeft(text, " \t\r\n")
+	label, rest, _ := strings.Cut(strings.ReplaceAll(text, "\n", " "), " ")
+
+	number, err := verseLabelNumber(label)
+	if err != nil && p.book.chapter != nil {
+		p.book.diagnose(fmt.Sprintf("invalid verse number %q", label))
+	}
+
+	p.book.startVerse(label, number)
+	p.text(rest)
+}
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func TestImportUsfm_RoundTrip(t *testing.T) {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+
+	usfm, err := ExportUsfm(book, chapters, verses, marks, headings, psalms)
+	if err != nil {
+		t.Fatalf("ExportUsfm() error = %v", err)
+	}
+
+	result, err := ImportUsfm(usfm)
+	if err != nil {
+		t.Fatalf("ImportUsfm() error = %v", err)
+	}
+
+	if len(result.Diagnostics) > 0 {
+		t.Errorf("ImportUsfm() diagnostics = %+v, want none", result.Diagnostics)
+	}
+
+	if len(result.Verses) != len(verses) || len(result.Marks) != len(marks) || len(result.Headings) != len(headings) || len(result.Psalms) != len(psalms) {
+		t.Errorf("ImportUsfm() = %d verses, %d marks, %d headings, %d psalms, want %d, %d, %d, %d", len(result.Verses), len(result.Marks), len(result.Headings), len(result.Psalms), len(verses), len(marks), len(headings), len(psalms))
+	}
+
+	reexported, err := ExportUsfm(result.Book, result.Chapters, result.Verses, result.Marks, result.Headings, result.Psalms)
+	if err != nil {
+		t.Fatalf("ExportUsfm() error = %v", err)
+	}
+
+	if reexported != usfm {
+		t.Errorf("ExportUsfm(ImportUsfm()) = %q, want %q", reexported, usfm)
+	}
+}
+
+func TestImportUsfm_SubVerseHeadingRoundTrip(t *testing.T) {
+	book := &biblev1.Book{Id: "jhn", Code: "jhn", Name: "John"}
+	chapters := []*biblev1.Chapter{
+		{Id: "JHN.1", Number: 1, BookId: "jhn"},
+	}
+	verses := []*biblev1.Verse{
+		{Id: "JHN.1.1", Number: 1, Label: "1", Text: "First part.", ChapterId: "JHN.1"},
+		{Id: "JHN.1.1b", Number: 1, Label: "1b", Text: "Second part.", SubVerseIndex: 1, ChapterId: "JHN.1"},
+	}
+	headings := []*biblev1.Heading{
+		{Id: "h1", Text: "A Heading", Level: 1, VerseId: "JHN.1.1b", ChapterId: "JHN.1"},
+	}
+
+	expected := `\id JHN
+\h John
+\c 1
+\p
+\v 1 First part.
+\s1 A Heading
+\m
+Second part.
+`
+
+	usfm, err := ExportUsfm(book, chapters, verses, nil, headings, nil)
+	if err != nil {
+		t.Fatalf("ExportUsfm() error = %v", err)
+	}
+
+	if usfm != expected {
+		t.Errorf("ExportUsfm() = %q, want %q", usfm, expected)
+	}
+
+	result, err := ImportUsfm(usfm)
+	if err != nil {
+		t.Fatalf("ImportUsfm() error = %v", err)
+	}
+
+	if len(result.Verses) != 2 || result.Verses[1].Text != "Second part." {
+		t.Fatalf("ImportUsfm() verses = %+v, want the sub-verse %q", result.Verses, "Second part.")
+	}
+
+	if len(result.Headings) != 1 || result.Headings[0].Text != "A Heading" || result.Headings[0].VerseId != result.Verses[1].Id {
+		t.Errorf("ImportUsfm() headings = %+v, want %q before the sub-verse", result.Headings, "A Heading")
+	}
+
+	reexported, err := ExportUsfm(result.Book, result.Chapters, result.Verses, result.Marks, result.Headings, result.Psalms)
+	if err != nil {
+		t.Fatalf("ExportUsfm() error = %v", err)
+	}
+
+	if reexported != usfm {
+		t.Errorf("ExportUsfm(ImportUsfm()) = %q, want %q", reexported, usfm)
+	}
+}
+
+func TestImportUsfm(t *testing.T) {
+	usfm := `\id MAT Matthew
+\toc1 The Gospel of Matthew
+\mt1 Matthew
+\c 5
+\ms Sermon on the Mount
+\s1 The Beatitudes
+\p
+\v 3 \wj Blessed are the \w poor|lemma="ptochos"\w* in spirit,\f + \fr 5.3 \ft Or \fq humble\fq*\f*
+\v 4 blessed are those who mourn.\wj*
+\v 5 Some \zz odd\zz* text~here.
+`
+
+	result, err := ImportUsfm(usfm)
+	if err != nil {
+		t.Fatalf("ImportUsfm() error = %v", err)
+	}
+
+	expectedVerses := []*biblev1.Verse{
+		{Id: "MAT.5.3", Text: "Blessed are the poor in spirit,", Label: "3", Number: 3, ChapterId: "MAT.5"},
+		{Id: "MAT.5.4", Text: "blessed are those who mourn.", Label: "4", Number: 4, ParagraphIndex: 1, ChapterId: "MAT.5"},
+		{Id: "MAT.5.5", Text: "Some odd text\u00a0here.", Label: "5", Number: 5, ParagraphIndex: 2, ChapterId: "MAT.5"},
+	}
+
+	if len(result.Verses) != len(expectedVerses) {
+		t.Fatalf("ImportUsfm() verses = %+v, want %+v", result.Verses, expectedVerses)
+	}
+
+	for i, verse := range result.Verses {
+		expected := expectedVerses[i]
+
+		if verse.Id != expected.Id || verse.Text != expected.Text || verse.Label != expected.Label || verse.Number != expected.Number || verse.ParagraphIndex != expected.ParagraphIndex {
+			t.Errorf("ImportUsfm() verse %d = %+v, want %+v", i, verse, expected)
+		}
+	}
+
+	expectedMarks := []*biblev1.Mark{
+		// NOTE: Words of Jesus spanning two verses are split with the same Id
+		{Id: "MAT.5.woj1", Content: "Blessed are the poor in spirit,", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 31, TargetId: "MAT.5.3"},
+		{Id: "MAT.5.fn1", Content: "Or humble", Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, StartOffset: 31, EndOffset: 31, TargetId: "MAT.5.3"},
+		{Id: "MAT.5.woj1", Content: "blessed are those who mourn.", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 0, EndOffset: 28, TargetId: "MAT.5.4"},
+	}
+
+	if len(result.Marks) != len(expectedMarks) {
+		t.Fatalf("ImportUsfm() marks = %+v, want %+v", result.Marks, expectedMarks)
+	}
+
+	for i, mark := range result.Marks {
+		expected := expectedMarks[i]
+
+		if mark.Id != expected.Id || mark.Content != expected.Content || mark.Kind != expected.Kind || mark.StartOffset != expected.StartOffset || mark.EndOffset != expected.EndOffset || mark.TargetId != expected.TargetId {
+			t.Errorf("ImportUsfm() mark %d = %+v, want %+v", i, mark, expected)
+		}
+	}
+
+	if len(result.Headings) != 1 || result.Headings[0].Text != "The Beatitudes" || result.Headings[0].VerseId != "MAT.5.3" {
+		t.Errorf("ImportUsfm() headings = %+v", result.Headings)
+	}
+
+	expectedDiagnostics := []ImportDiagnostic{
+		{Line: 5, Marker: "ms"},
+		{Line: 10, Marker: "zz"},
+		{Line: 10, Marker: "zz"},
+	}
+
+	if len(result.Diagnostics) != len(expectedDiagnostics) {
+		t.Fatalf("ImportUsfm() diagnostics = %+v, want %+v", result.Diagnostics, expectedDiagnostics)
+	}
+
+	for i, diagnostic := range result.Diagnostics {
+		if diagnostic.Line != expectedDiagnostics[i].Line || diagnostic.Marker != expectedDiagnostics[i].Marker {
+			t.Errorf("ImportUsfm() diagnostic %d = %+v, want %+v", i, diagnostic, expectedDiagnostics[i])
+		}
+	}
+}
+
+func TestImportUsfm_MissingBookId(t *testing.T) {
+	_, err := ImportUsfm("\\c 1\n\\p\n\\v 1 In the beginning.\n")
+	if !errors.Is(err, ErrUsfmMissingBookId) {
+		t.Errorf("ImportUsfm() error = %v, want %v", err, ErrUsfmMissingBookId)
+	}
+}
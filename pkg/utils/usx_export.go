@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/samber/lo"
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+var ErrUsxChapterNotFound = errors.New("usx chapter not found")
+
+var usxMarkLabels = map[biblev1.MarkKind]MarkLabelFunc{
+	biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`<note caller="+" style="f"><char style="ft">%s</char></note>`, usxText(mark.Content))
+	},
+	biblev1.MarkKind_MARK_KIND_REFERENCE: func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`<note caller="-" style="x"><char style="xt">%s</char></note>`, usxText(mark.Content))
+	},
+	biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`<char style="wj">%s</char>`, mark.Content)
+	},
+}
+
+// NOTE: Converts Markdown text to escaped XML text
+func usxText(md string) string {
+	return htmlEscaper.Replace(mdToText(md))
+}
+
+// ExportUsx exports a book to USX 3.0, the XML form of USFM, with the same
+// structure as ExportUsfm: <chapter> and <verse> milestones, <para> for
+// headings, psalm titles, paragraphs and poetry lines, <note> for footnotes
+// and cross references and <char style="wj"> for words of Jesus. book may be
+// nil to omit the <book> element. The document is written to w.
+func ExportUsx(w io.Writer, book *biblev1.Book, chapters []*biblev1.Chapter, verses []*biblev1.Verse, marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata) error {
+	chapterNumbers := make(map[string]int32, len(chapters))
+
+	for _, chapter := range chapters {
+		chapterNumbers[chapter.Id] = chapter.Number
+	}
+
+	markIndex := NewMarkIndex(marks)
+	headingsByVerse := lo.GroupBy(headings, func(h *biblev1.Heading) string {
+		return h.VerseId
+	})
+	psalmsByChapter := lo.GroupBy(psalms, func(p *biblev1.PsalmMetadata) string {
+		return p.ChapterId
+	})
+
+	xw := &xmlWriter{w: w}
+
+	xw.write(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	xw.write(`<usx version="3.0">` + "\n")
+
+	code := ""
+
+	if book != nil {
+		code = htmlEscaper.Replace(strings.ToUpper(book.Code))
+
+		xw.write(fmt.Sprintf(`<book code="%s" style="id"/>`, code) + "\n")
+
+		if book.Name != "" {
+			xw.write(fmt.Sprintf(`<para style="h">%s</para>`, usxText(book.Name)) + "\n")
+		}
+	}
+
+	// NOTE: The sid or eid attribute of a milestone, omitted without the book
+	// code
+	milestone := func(attr, ref string) string {
+		if code == "" {
+			return ""
+		}
+
+		return fmt.Sprintf(` %s="%s %s"`, attr, code, ref)
+	}
+
+	currentChapterId := ""
+	chapterNumber := int32(0)
+	currPar := int32(-1)
+	prevIsPoetry := false
+	paraOpen := false
+
+	closePara := func() {
+		if paraOpen {
+			xw.write("</para>\n")
+		}
+
+		paraOpen = false
+	}
+
+	closeChapter := func() {
+		closePara()
+
+		if currentChapterId != "" && code != "" {
+			xw.write(fmt.Sprintf(`<chapter%s/>`, milestone("eid", fmt.Sprint(chapterNumber))) + "\n")
+		}
+	}
+
+	for i, verse := range verses {
+		newChapter := verse.ChapterId != currentChapterId
+
+		if newChapter {
+			number, ok := chapterNumbers[verse.ChapterId]
+			if !ok {
+				return fmt.Errorf("%w: %s", ErrUsxChapterNotFound, verse.ChapterId)
+			}
+
+			closeChapter()
+
+			chapterNumber = number
+
+			xw.write(fmt.Sprintf(`<chapter number="%d" style="c"%s/>`, number, milestone("sid", fmt.Sprint(number))) + "\n")
+
+			currentChapterId = verse.ChapterId
+			currPar = -1
+			prevIsPoetry = false
+		}
+
+		for _, heading := range headingsByVerse[verse.Id] {
+			headingMarks := markIndex.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_HEADING, heading.Id, biblev1.MarkKind_MARK_KIND_FOOTNOTE, biblev1.MarkKind_MARK_KIND_REFERENCE)
+
+			level := min(max(int(heading.Level), 1), maxUsfmHeading)
+
+			closePara()
+			xw.write(fmt.Sprintf(`<para style="s%d">%s</para>`, level, exportInline(heading.Text, headingMarks, usxText, usxMarkLabels)) + "\n")
+		}
+
+		if newChapter {
+			for _, psalm := range psalmsByChapter[verse.ChapterId] {
+				closePara()
+				xw.write(fmt.Sprintf(`<para style="d">%s</para>`, usxText(psalm.Text)) + "\n")
+			}
+		}
+
+		verseMarks := markIndex.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, verse.Id, biblev1.MarkKind_MARK_KIND_FOOTNOTE, biblev1.MarkKind_MARK_KIND_REFERENCE, biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS)
+
+		content := exportInline(verse.Text, verseMarks, usxText, usxMarkLabels)
+		verseRef := fmt.Sprintf("%d:%d", chapterNumber, verse.Number)
+
+		// NOTE: Sub-verses continue the verse of the previous milestone
+		if verse.SubVerseIndex == 0 {
+			content = fmt.Sprintf(`<verse number="%d" style="v"%s/>`, verse.Number, milestone("sid", verseRef)) + content
+		}
+
+		continued := i+1 < len(verses) && verses[i+1].SubVerseIndex > 0 && verses[i+1].ChapterId == verse.ChapterId && verses[i+1].Number == verse.Number
+		if !continued && code != "" {
+			content += fmt.Sprintf(`<verse%s/>`, milestone("eid", verseRef))
+		}
+
+		newParagraph := verse.ParagraphNumber != currPar
+
+		switch {
+		case verse.IsPoetry:
+			// NOTE: Each poetry verse is one line of poetry
+			closePara()
+			xw.write(fmt.Sprintf(`<para style="q1">%s</para>`, content) + "\n")
+		case newParagraph:
+			closePara()
+			xw.write(`<para style="p">` + content)
+
+			paraOpen = true
+		case prevIsPoetry || !paraOpen:
+			// NOTE: Prose continuing the paragraph after poetry or a heading
+			closePara()
+			xw.write(`<para style="m">` + content)
+
+			paraOpen = true
+		case verse.SubVerseIndex > 0:
+			xw.write(" " + content)
+		default:
+			xw.write("\n" + content)
+		}
+
+		currPar = verse.ParagraphNumber
+		prevIsPoetry = verse.IsPoetry
+	}
+
+	closeChapter()
+
+	xw.write("</usx>\n")
+
+	return xw.err
+}
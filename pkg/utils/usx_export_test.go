@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExportUsx(t *testing.T) {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+
+	expected := `<?xml version="1.0" encoding="utf-8"?>
+<usx version="3.0">
+<book code="PSA" style="id"/>
+<para style="h">Psalms</para>
+<chapter number="23" style="c" sid="PSA 23"/>
+<para style="s1">The Good<note caller="+" style="f"><char style="ft">Or life</char></note> Shepherd</para>
+<para style="d">A Psalm of David.</para>
+<para style="q1"><verse number="1" style="v" sid="PSA 23:1"/>The Lord is my shepherd<note caller="+" style="f"><char style="ft">Or keeper</char></note>;</para>
+<para style="q1">I shall not want.<verse eid="PSA 23:1"/></para>
+<para style="m"><verse number="2" style="v" sid="PSA 23:2"/>He said, <char style="wj">Follow<note caller="-" style="x"><char style="xt">Mt 4:19</char></note> me</char>.<verse eid="PSA 23:2"/></para>
+<para style="p"><verse number="3" style="v" sid="PSA 23:3"/>He restores my soul.<verse eid="PSA 23:3"/></para>
+<chapter eid="PSA 23"/>
+<chapter number="24" style="c" sid="PSA 24"/>
+<para style="s3">The King of Glory</para>
+<para style="p"><verse number="1" style="v" sid="PSA 24:1"/>The earth is the Lord's.<verse eid="PSA 24:1"/></para>
+<chapter eid="PSA 24"/>
+</usx>
+`
+
+	var sb strings.Builder
+
+	if err := ExportUsx(&sb, book, chapters, verses, marks, headings, psalms); err != nil {
+		t.Fatalf("ExportUsx() error = %v", err)
+	}
+
+	if sb.String() != expected {
+		t.Errorf("ExportUsx() = %q, want %q", sb.String(), expected)
+	}
+}
+
+func TestExportUsx_ChapterNotFound(t *testing.T) {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+
+	err := ExportUsx(&strings.Builder{}, book, chapters[:1], verses, marks, headings, psalms)
+	if !errors.Is(err, ErrUsxChapterNotFound) {
+		t.Errorf("ExportUsx() error = %v, want %v", err, ErrUsxChapterNotFound)
+	}
+}
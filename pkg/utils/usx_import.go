@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+var (
+	ErrUsxMissingBookId = errors.New("usx book id not found")
+	ErrUsxSyntax        = errors.New("invalid usx xml")
+)
+
+// NOTE: Elements without text, dropped silently
+var usxEmptyElements = []string{"optbreak", "ms"}
+
+// ImportUsx parses a USX book into verses, headings, marks and psalm titles,
+// like ImportUsfm: the style of <para>, <note> and <char> elements is read as
+// the USFM marker of the same name. Mark offsets are rune offsets in the verse
+// text, as used by ResolveMarks. Elements and styles that cannot be imported
+// are reported in Diagnostics.
+func ImportUsx(r io.Reader) (*BookData, error) {
+	p := &usfmParser{book: newBookBuilder(), usx: true}
+
+	decoder := xml.NewDecoder(r)
+
+	// NOTE: Markers closed at the end of the open elements, empty if none
+	closers := make([]string, 0)
+
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUsxSyntax, err)
+		}
+
+		p.book.line, _ = decoder.InputPos()
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			closers = append(closers, p.openUsxElement(t))
+		case xml.EndElement:
+			closer := closers[len(closers)-1]
+			closers = closers[:len(closers)-1]
+
+			if closer != "" {
+				p.book.marker = closer
+				p.closeMarker(closer)
+			}
+		case xml.CharData:
+			p.book.marker = ""
+			p.text(string(t))
+		}
+	}
+
+	if p.book.code == "" {
+		return nil, ErrUsxMissingBookId
+	}
+
+	return p.book.finish(p.name.String()), nil
+}
+
+// NOTE: Reads the start of a USX element as USFM markers, returns the marker
+// to close at its end
+func (p *usfmParser) openUsxElement(element xml.StartElement) string {
+	name := element.Name.Local
+	style := xmlAttr(element, "style")
+
+	p.book.marker = style
+
+	switch name {
+	case "usx":
+	case "book":
+		p.book.marker = "id"
+		p.openMarker("id")
+		p.text(xmlAttr(element, "code"))
+	case "chapter":
+		// NOTE: The end of a chapter is the start of the next one
+		if xmlAttr(element, "eid") != "" {
+			return ""
+		}
+
+		p.book.marker = "c"
+		p.openMarker("c")
+		p.text(xmlAttr(element, "number"))
+	case "verse":
+		if xmlAttr(element, "eid") != "" {
+			p.book.endVerse()
+
+			return ""
+		}
+
+		p.book.marker = "v"
+		p.openMarker("v")
+		p.text(xmlAttr(element, "number"))
+	case "para":
+		p.openMarker(style)
+	case "note":
+		p.openMarker(style)
+
+		// NOTE: The caller is an attribute in USX
+		p.noteCallerRead = true
+
+		return style
+	case "char":
+		p.openMarker(style)
+
+		return style
+	case "figure":
+		p.book.marker = "fig"
+		p.openMarker("fig")
+
+		return "fig"
+	default:
+		if !slices.Contains(usxEmptyElements, name) {
+			p.diagnose(name, fmt.Sprintf("unsupported element <%s>, its content is kept as text", name))
+		}
+	}
+
+	return ""
+}
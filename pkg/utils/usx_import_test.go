@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func TestImportUsx_RoundTrip(t *testing.T) {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+
+	var usx strings.Builder
+
+	if err := ExportUsx(&usx, book, chapters, verses, marks, headings, psalms); err != nil {
+		t.Fatalf("ExportUsx() error = %v", err)
+	}
+
+	result, err := ImportUsx(strings.NewReader(usx.String()))
+	if err != nil {
+		t.Fatalf("ImportUsx() error = %v", err)
+	}
+
+	if len(result.Diagnostics) > 0 {
+		t.Errorf("ImportUsx() diagnostics = %+v, want none", result.Diagnostics)
+	}
+
+	var reexported strings.Builder
+
+	if err := ExportUsx(&reexported, result.Book, result.Chapters, result.Verses, result.Marks, result.Headings, result.Psalms); err != nil {
+		t.Fatalf("ExportUsx() error = %v", err)
+	}
+
+	if reexported.String() != usx.String() {
+		t.Errorf("ExportUsx(ImportUsx()) = %q, want %q", reexported.String(), usx.String())
+	}
+
+	// NOTE: USX and USFM share their structure
+	usfm, _ := ExportUsfm(book, chapters, verses, marks, headings, psalms)
+	usfmFromUsx, _ := ExportUsfm(result.Book, result.Chapters, result.Verses, result.Marks, result.Headings, result.Psalms)
+
+	if usfmFromUsx != usfm {
+		t.Errorf("ExportUsfm(ImportUsx()) = %q, want %q", usfmFromUsx, usfm)
+	}
+}
+
+func TestImportUsx(t *testing.T) {
+	usx := `<?xml version="1.0" encoding="utf-8"?>
+<usx version="3.0">
+  <book code="JHN" style="id">World English Bible</book>
+  <para style="h">John</para>
+  <para style="toc1">The Good News According to John</para>
+  <chapter number="11" style="c" sid="JHN 11"/>
+  <para style="ms">Part One</para>
+  <para style="p">
+    <verse number="35" style="v" sid="JHN 11:35"/><char style="nd">Jesus</char> wept~.<note caller="+" style="f"><char style="fr">11:35 </char><char style="ft">Or shed tears</char></note><verse eid="JHN 11:35"/>
+    <verse number="43" style="v" sid="JHN 11:43"/>He cried, <char style="wj" strong="G2976">Lazarus, come out!</char><optbreak/><verse eid="JHN 11:43"/>
+  </para>
+  <figure style="fig" file="tomb.jpg">The tomb</figure>
+  <table><row><cell>x</cell></row></table>
+  <chapter eid="JHN 11"/>
+</usx>`
+
+	result, err := ImportUsx(strings.NewReader(usx))
+	if err != nil {
+		t.Fatalf("ImportUsx() error = %v", err)
+	}
+
+	if result.Book.Code != "JHN" || result.Book.Name != "John" {
+		t.Errorf("ImportUsx() book = %+v, want JHN John", result.Book)
+	}
+
+	expectedVerses := []string{"Jesus wept~.", "He cried, Lazarus, come out!"}
+
+	if len(result.Verses) != len(expectedVerses) {
+		t.Fatalf("ImportUsx() = %d verses, want %d", len(result.Verses), len(expectedVerses))
+	}
+
+	for i, verse := range result.Verses {
+		if verse.Text != expectedVerses[i] {
+			t.Errorf("ImportUsx() verse %d = %q, want %q", i, verse.Text, expectedVerses[i])
+		}
+	}
+
+	expectedMarks := []*biblev1.Mark{
+		{Kind: biblev1.MarkKind_MARK_KIND_FOOTNOTE, Content: "Or shed tears", StartOffset: 12, EndOffset: 12, TargetId: "JHN.11.35"},
+		{Content: "Lazarus, come out!", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 10, EndOffset: 28, TargetId: "JHN.11.43"},
+	}
+
+	if len(result.Marks) != len(expectedMarks) {
+		t.Fatalf("ImportUsx() marks = %+v, want %+v", result.Marks, expectedMarks)
+	}
+
+	for i, mark := range result.Marks {
+		expected := expectedMarks[i]
+
+		if mark.Kind != expected.Kind || mark.Content != expected.Content || mark.StartOffset != expected.StartOffset || mark.EndOffset != expected.EndOffset || mark.TargetId != expected.TargetId {
+			t.Errorf("ImportUsx() mark %d = %+v, want %+v", i, mark, expected)
+		}
+	}
+
+	expectedDiagnostics := []string{"ms", "fig", "table", "row", "cell", ""}
+
+	if len(result.Diagnostics) != len(expectedDiagnostics) {
+		t.Fatalf("ImportUsx() diagnostics = %+v, want %v", result.Diagnostics, expectedDiagnostics)
+	}
+
+	for i, diagnostic := range result.Diagnostics {
+		if diagnostic.Marker != expectedDiagnostics[i] {
+			t.Errorf("ImportUsx() diagnostic %d = %+v, want %s", i, diagnostic, expectedDiagnostics[i])
+		}
+	}
+}
+
+func TestImportUsx_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected error
+	}{
+		{
+			name:     "missing book id",
+			input:    `<usx version="3.0"><chapter number="1" style="c"/></usx>`,
+			expected: ErrUsxMissingBookId,
+		},
+		{
+			name:     "invalid xml",
+			input:    `<usx version="3.0"><book code="GEN" style="id"><para>`,
+			expected: ErrUsxSyntax,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ImportUsx(strings.NewReader(tt.input))
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("ImportUsx() error = %v, want %v", err, tt.expected)
+			}
+		})
+	}
+}
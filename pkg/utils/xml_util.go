@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// NOTE: Writes strings until the first error, which is kept in err
+type xmlWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *xmlWriter) write(parts ...string) {
+	for _, part := range parts {
+		if w.err != nil {
+			return
+		}
+
+		_, w.err = io.WriteString(w.w, part)
+	}
+}
+
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+
+	return ""
+}
+
+// NOTE: Reads the content of the element just started until its end. Child
+// elements for which drop, if not nil, returns true are skipped with their
+// content, open handles the start of the others and returns the function to
+// call at their end, which may be nil.
+func readXmlElement(decoder *xml.Decoder, book *bookBuilder, drop func(element xml.StartElement) bool, open func(element xml.StartElement) func(), text func(text string)) error {
+	closers := make([]func(), 0)
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		book.line, _ = decoder.InputPos()
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			book.marker = t.Name.Local
+
+			if drop != nil && drop(t) {
+				if err := decoder.Skip(); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			closers = append(closers, open(t))
+		case xml.EndElement:
+			book.marker = t.Name.Local
+
+			if len(closers) == 0 {
+				return nil
+			}
+
+			if closer := closers[len(closers)-1]; closer != nil {
+				closer()
+			}
+
+			closers = closers[:len(closers)-1]
+		case xml.CharData:
+			text(string(t))
+		}
+	}
+}
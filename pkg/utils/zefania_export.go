@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/samber/lo"
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+var (
+	ErrZefaniaChapterNotFound = errors.New("zefania chapter not found")
+	ErrZefaniaBookNotFound    = errors.New("zefania book not found")
+	ErrZefaniaWriterClosed    = errors.New("zefania writer closed")
+)
+
+// NOTE: Zefania has no element for words of Jesus, red letter Bibles use a
+// red STYLE
+const zefaniaWordsOfJesusCss = "color:#ff0000"
+
+var zefaniaMarkLabels = map[biblev1.MarkKind]MarkLabelFunc{
+	biblev1.MarkKind_MARK_KIND_FOOTNOTE: func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`<NOTE type="x-studynote">%s</NOTE>`, zefaniaText(mark.Content))
+	},
+	biblev1.MarkKind_MARK_KIND_REFERENCE: func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`<NOTE type="x-crossref">%s</NOTE>`, zefaniaText(mark.Content))
+	},
+	biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS: func(mark *biblev1.Mark, chapterId string) string {
+		return fmt.Sprintf(`<STYLE css="%s">%s</STYLE>`, zefaniaWordsOfJesusCss, mark.Content)
+	},
+}
+
+// NOTE: Converts Markdown text to escaped XML text
+func zefaniaText(md string) string {
+	return htmlEscaper.Replace(mdToText(md))
+}
+
+// ZefaniaWriter writes books to a Zefania XML document one at a time.
+// Headings are written as <CAPTION>, psalm titles as <PROLOG>, footnotes and
+// references as <NOTE> and words of Jesus as a red <STYLE>. Zefania has no
+// poetry, sub-verses are joined in one <VERS> and paragraphs are separated by
+// <BR art="x-p"/>. Close must be called to end the document.
+type ZefaniaWriter struct {
+	xmlWriter
+	name    string
+	started bool
+	closed  bool
+}
+
+// NewZefaniaWriter creates a ZefaniaWriter writing to w. name is the
+// biblename of the document, "Bible" if empty.
+func NewZefaniaWriter(w io.Writer, name string) *ZefaniaWriter {
+	if name == "" {
+		name = "Bible"
+	}
+
+	return &ZefaniaWriter{
+		xmlWriter: xmlWriter{w: w},
+		name:      name,
+	}
+}
+
+// ExportZefania writes a book as a Zefania XML document to w.
+func ExportZefania(w io.Writer, book *biblev1.Book, chapters []*biblev1.Chapter, verses []*biblev1.Verse, marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata) error {
+	zefaniaWriter := NewZefaniaWriter(w, "")
+
+	if err := zefaniaWriter.WriteBook(book, chapters, verses, marks, headings, psalms); err != nil {
+		return err
+	}
+
+	return zefaniaWriter.Close()
+}
+
+func (w *ZefaniaWriter) start() {
+	if w.started {
+		return
+	}
+
+	w.started = true
+
+	name := htmlEscaper.Replace(w.name)
+
+	w.write(
+		`<?xml version="1.0" encoding="utf-8"?>`+"\n",
+		fmt.Sprintf(`<XMLBIBLE biblename="%s" type="x-bible">`, name)+"\n",
+		fmt.Sprintf(`<INFORMATION><title>%s</title></INFORMATION>`, name)+"\n",
+	)
+}
+
+// WriteBook writes a book to the document. chapters provides the chapter
+// numbers, verses must be in reading order. The bnumber of the book is its
+// BookOrder.
+func (w *ZefaniaWriter) WriteBook(book *biblev1.Book, chapters []*biblev1.Chapter, verses []*biblev1.Verse, marks []*biblev1.Mark, headings []*biblev1.Heading, psalms []*biblev1.PsalmMetadata) error {
+	if w.closed {
+		return ErrZefaniaWriterClosed
+	}
+
+	if book == nil {
+		return ErrZefaniaBookNotFound
+	}
+
+	chapterNumbers := make(map[string]int32, len(chapters))
+
+	for _, chapter := range chapters {
+		chapterNumbers[chapter.Id] = chapter.Number
+	}
+
+	// NOTE: Checked before writing, so a failed book is not half written
+	for _, verse := range verses {
+		if _, ok := chapterNumbers[verse.ChapterId]; !ok {
+			return fmt.Errorf("%w: %s", ErrZefaniaChapterNotFound, verse.ChapterId)
+		}
+	}
+
+	markIndex := NewMarkIndex(marks)
+	headingsByVerse := lo.GroupBy(headings, func(h *biblev1.Heading) string {
+		return h.VerseId
+	})
+	psalmsByChapter := lo.GroupBy(psalms, func(p *biblev1.PsalmMetadata) string {
+		return p.ChapterId
+	})
+
+	w.start()
+
+	w.write(fmt.Sprintf(`<BIBLEBOOK bnumber="%d" bname="%s" bsname="%s">`, book.BookOrder, zefaniaText(book.Name), htmlEscaper.Replace(book.Code)), "\n")
+
+	currentChapterId := ""
+	currPar := int32(-1)
+
+	// NOTE: Content of the <VERS> being written, sub-verses are added to it
+	var sb strings.Builder
+
+	for i, verse := range verses {
+		if verse.ChapterId != currentChapterId {
+			if currentChapterId != "" {
+				w.write("</CHAPTER>\n")
+			}
+
+			w.write(fmt.Sprintf(`<CHAPTER cnumber="%d">`, chapterNumbers[verse.ChapterId]), "\n")
+
+			for _, psalm := range psalmsByChapter[verse.ChapterId] {
+				w.write(fmt.Sprintf(`<PROLOG>%s</PROLOG>`, zefaniaText(psalm.Text)), "\n")
+			}
+
+			currentChapterId = verse.ChapterId
+			currPar = -1
+		}
+
+		if verse.SubVerseIndex == 0 {
+			if currPar != -1 && verse.ParagraphNumber != currPar {
+				w.write(`<BR art="x-p"/>`, "\n")
+			}
+
+			for _, heading := range headingsByVerse[verse.Id] {
+				headingMarks := markIndex.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_HEADING, heading.Id, biblev1.MarkKind_MARK_KIND_FOOTNOTE, biblev1.MarkKind_MARK_KIND_REFERENCE)
+
+				w.write(fmt.Sprintf(`<CAPTION vref="%d">%s</CAPTION>`, verse.Number, exportInline(heading.Text, headingMarks, zefaniaText, zefaniaMarkLabels)), "\n")
+			}
+
+			sb.Reset()
+		} else {
+			sb.WriteString(" ")
+		}
+
+		verseMarks := markIndex.Target(biblev1.MarkTargetType_MARK_TARGET_TYPE_VERSE, verse.Id, biblev1.MarkKind_MARK_KIND_FOOTNOTE, biblev1.MarkKind_MARK_KIND_REFERENCE, biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS)
+
+		sb.WriteString(exportInline(verse.Text, verseMarks, zefaniaText, zefaniaMarkLabels))
+
+		continued := i+1 < len(verses) && verses[i+1].SubVerseIndex > 0 && verses[i+1].ChapterId == verse.ChapterId && verses[i+1].Number == verse.Number
+		if !continued {
+			w.write(fmt.Sprintf(`<VERS vnumber="%d">%s</VERS>`, verse.Number, sb.String()), "\n")
+		}
+
+		currPar = verse.ParagraphNumber
+	}
+
+	if currentChapterId != "" {
+		w.write("</CHAPTER>\n")
+	}
+
+	w.write("</BIBLEBOOK>\n")
+
+	return w.err
+}
+
+// Close ends the document. It does not close the underlying io.Writer.
+func (w *ZefaniaWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+
+	w.start()
+	w.write("</XMLBIBLE>\n")
+
+	w.closed = true
+
+	return w.err
+}
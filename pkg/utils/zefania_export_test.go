@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExportZefania(t *testing.T) {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+	book.BookOrder = 19
+
+	expected := `<?xml version="1.0" encoding="utf-8"?>
+<XMLBIBLE biblename="Bible" type="x-bible">
+<INFORMATION><title>Bible</title></INFORMATION>
+<BIBLEBOOK bnumber="19" bname="Psalms" bsname="psa">
+<CHAPTER cnumber="23">
+<PROLOG>A Psalm of David.</PROLOG>
+<CAPTION vref="1">The Good<NOTE type="x-studynote">Or life</NOTE> Shepherd</CAPTION>
+<VERS vnumber="1">The Lord is my shepherd<NOTE type="x-studynote">Or keeper</NOTE>; I shall not want.</VERS>
+<VERS vnumber="2">He said, <STYLE css="color:#ff0000">Follow<NOTE type="x-crossref">Mt 4:19</NOTE> me</STYLE>.</VERS>
+<BR art="x-p"/>
+<VERS vnumber="3">He restores my soul.</VERS>
+</CHAPTER>
+<CHAPTER cnumber="24">
+<CAPTION vref="1">The King of Glory</CAPTION>
+<VERS vnumber="1">The earth is the Lord's.</VERS>
+</CHAPTER>
+</BIBLEBOOK>
+</XMLBIBLE>
+`
+
+	var sb strings.Builder
+
+	if err := ExportZefania(&sb, book, chapters, verses, marks, headings, psalms); err != nil {
+		t.Fatalf("ExportZefania() error = %v", err)
+	}
+
+	if sb.String() != expected {
+		t.Errorf("ExportZefania() = %q, want %q", sb.String(), expected)
+	}
+}
+
+func TestZefaniaWriter_Errors(t *testing.T) {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+
+	var sb strings.Builder
+
+	zefaniaWriter := NewZefaniaWriter(&sb, "WEB")
+
+	if err := zefaniaWriter.WriteBook(book, chapters[:1], verses, marks, headings, psalms); !errors.Is(err, ErrZefaniaChapterNotFound) {
+		t.Errorf("WriteBook() error = %v, want %v", err, ErrZefaniaChapterNotFound)
+	}
+
+	if err := zefaniaWriter.WriteBook(nil, chapters, verses, marks, headings, psalms); !errors.Is(err, ErrZefaniaBookNotFound) {
+		t.Errorf("WriteBook() error = %v, want %v", err, ErrZefaniaBookNotFound)
+	}
+
+	if err := zefaniaWriter.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if err := zefaniaWriter.WriteBook(book, chapters, verses, marks, headings, psalms); !errors.Is(err, ErrZefaniaWriterClosed) {
+		t.Errorf("WriteBook() error = %v, want %v", err, ErrZefaniaWriterClosed)
+	}
+
+	expected := `<?xml version="1.0" encoding="utf-8"?>
+<XMLBIBLE biblename="WEB" type="x-bible">
+<INFORMATION><title>WEB</title></INFORMATION>
+</XMLBIBLE>
+`
+
+	if sb.String() != expected {
+		t.Errorf("ZefaniaWriter = %q, want %q", sb.String(), expected)
+	}
+}
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+var (
+	ErrZefaniaMissingBookId = errors.New("zefania book id not found")
+	ErrZefaniaSyntax        = errors.New("invalid zefania xml")
+)
+
+// ZefaniaReader reads the books of a Zefania XML document one at a time.
+// Elements with no counterpart in the SDK, e.g. <gr> or <STYLE fs="bold">,
+// keep their text.
+type ZefaniaReader struct {
+	decoder *xml.Decoder
+}
+
+// NewZefaniaReader creates a ZefaniaReader reading from r.
+func NewZefaniaReader(r io.Reader) *ZefaniaReader {
+	return &ZefaniaReader{
+		decoder: xml.NewDecoder(r),
+	}
+}
+
+// ImportZefania reads all the books of a Zefania XML document.
+func ImportZefania(r io.Reader) ([]*BookData, error) {
+	zefaniaReader := NewZefaniaReader(r)
+	books := make([]*BookData, 0)
+
+	for {
+		book, err := zefaniaReader.Next()
+		if errors.Is(err, io.EOF) {
+			return books, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		books = append(books, book)
+	}
+}
+
+// Next reads the next <BIBLEBOOK> of the document. It returns io.EOF after the
+// last book. The book code is the bsname of the book, or its bnumber without
+// one. Mark offsets are rune offsets in the verse text, after notes are
+// stripped.
+func (r *ZefaniaReader) Next() (*BookData, error) {
+	for {
+		token, err := r.decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrZefaniaSyntax, err)
+		}
+
+		if element, ok := token.(xml.StartElement); ok && strings.EqualFold(element.Name.Local, "BIBLEBOOK") {
+			return r.readBook(element)
+		}
+	}
+}
+
+type zefaniaParser struct {
+	book *bookBuilder
+}
+
+func (r *ZefaniaReader) readBook(element xml.StartElement) (*BookData, error) {
+	p := &zefaniaParser{book: newBookBuilder()}
+
+	bookOrder, _ := strconv.Atoi(xmlAttr(element, "bnumber"))
+
+	p.book.code = xmlAttr(element, "bsname")
+	if p.book.code == "" {
+		p.book.code = xmlAttr(element, "bnumber")
+	}
+
+	if p.book.code == "" {
+		return nil, ErrZefaniaMissingBookId
+	}
+
+	if err := readXmlElement(r.decoder, p.book, p.dropElement, p.openElement, p.text); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrZefaniaSyntax, err)
+	}
+
+	data := p.book.finish(xmlAttr(element, "bname"))
+	data.Book.BookOrder = int32(bookOrder)
+
+	return data, nil
+}
+
+func (p *zefaniaParser) text(text string) {
+	p.book.write(text)
+}
+
+// NOTE: Whether element is dropped with its content
+func (p *zefaniaParser) dropElement(element xml.StartElement) bool {
+	if !strings.EqualFold(element.Name.Local, "MEDIA") {
+		return false
+	}
+
+	p.book.diagnose(fmt.Sprintf("unsupported element <%s>, its content is dropped", element.Name.Local))
+
+	return true
+}
+
+// NOTE: Handles the start of an element, returns the function to call at its
+// end
+func (p *zefaniaParser) openElement(element xml.StartElement) func() {
+	name := strings.ToUpper(element.Name.Local)
+
+	switch name {
+	case "CHAPTER":
+		number, err := strconv.Atoi(xmlAttr(element, "cnumber"))
+		if err != nil {
+			p.book.diagnose(fmt.Sprintf("invalid chapter number %q", xmlAttr(element, "cnumber")))
+
+			return nil
+		}
+
+		p.book.startChapter(number)
+	case "VERS":
+		label := xmlAttr(element, "vnumber")
+
+		number, err := strconv.Atoi(label)
+		if err != nil && p.book.chapter != nil {
+			p.book.diagnose(fmt.Sprintf("invalid verse number %q", label))
+		}
+
+		p.book.startVerse(label, number)
+
+		return p.book.endVerse
+	case "CAPTION":
+		p.book.startHeading(1)
+
+		return p.book.finishBlock
+	case "PROLOG":
+		p.book.startPsalm()
+
+		return p.book.finishBlock
+	case "NOTE":
+		if strings.Contains(strings.ToLower(xmlAttr(element, "type")), "crossref") {
+			p.book.openNote(biblev1.MarkKind_MARK_KIND_REFERENCE)
+		} else {
+			p.book.openNote(biblev1.MarkKind_MARK_KIND_FOOTNOTE)
+		}
+
+		return p.book.closeNote
+	case "XREF":
+		p.book.openNote(biblev1.MarkKind_MARK_KIND_REFERENCE)
+
+		return func() {
+			// NOTE: Cross references without text only have their scope, e.g.
+			// "40;4;19"
+			if p.book.note != nil && p.book.note.buffer.length == 0 {
+				p.book.note.buffer.write(xmlAttr(element, "mscope"))
+			}
+
+			p.book.closeNote()
+		}
+	case "STYLE":
+		if isZefaniaWordsOfJesus(xmlAttr(element, "css")) {
+			p.book.openWordsOfJesus()
+
+			return p.book.closeWordsOfJesus
+		}
+	case "BR":
+		if p.book.verseOpen {
+			p.book.write(" ")
+		} else if xmlAttr(element, "art") == "x-p" {
+			p.book.startParagraph(false)
+		}
+	}
+
+	return nil
+}
+
+// NOTE: Whether a STYLE css is red, e.g. "color: #FF0000;"
+func isZefaniaWordsOfJesus(css string) bool {
+	css = strings.TrimSuffix(strings.ToLower(strings.ReplaceAll(css, " ", "")), ";")
+
+	return css == zefaniaWordsOfJesusCss || css == "color:red"
+}
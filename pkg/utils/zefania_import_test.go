@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	biblev1 "github.com/v-bible/protobuf/pkg/proto/bible/v1"
+)
+
+func TestImportZefania_RoundTrip(t *testing.T) {
+	book, chapters, verses, marks, headings, psalms := usfmTestData()
+
+	var zefania strings.Builder
+
+	if err := ExportZefania(&zefania, book, chapters, verses, marks, headings, psalms); err != nil {
+		t.Fatalf("ExportZefania() error = %v", err)
+	}
+
+	books, err := ImportZefania(strings.NewReader(zefania.String()))
+	if err != nil {
+		t.Fatalf("ImportZefania() error = %v", err)
+	}
+
+	if len(books) != 1 {
+		t.Fatalf("ImportZefania() = %d books, want 1", len(books))
+	}
+
+	result := books[0]
+
+	if len(result.Diagnostics) > 0 {
+		t.Errorf("ImportZefania() diagnostics = %+v, want none", result.Diagnostics)
+	}
+
+	// NOTE: The sub-verse is joined to its verse
+	if len(result.Verses) != len(verses)-1 || len(result.Marks) != len(marks) || len(result.Headings) != len(headings) || len(result.Psalms) != len(psalms) {
+		t.Errorf("ImportZefania() = %d verses, %d marks, %d headings, %d psalms, want %d, %d, %d, %d", len(result.Verses), len(result.Marks), len(result.Headings), len(result.Psalms), len(verses)-1, len(marks), len(headings), len(psalms))
+	}
+
+	var reexported strings.Builder
+
+	if err := ExportZefania(&reexported, result.Book, result.Chapters, result.Verses, result.Marks, result.Headings, result.Psalms); err != nil {
+		t.Fatalf("ExportZefania() error = %v", err)
+	}
+
+	if reexported.String() != zefania.String() {
+		t.Errorf("ExportZefania(ImportZefania()) = %q, want %q", reexported.String(), zefania.String())
+	}
+}
+
+func TestZefaniaReader(t *testing.T) {
+	zefania := `<?xml version="1.0" encoding="utf-8"?>
+<XMLBIBLE biblename="Kinh Thánh" type="x-bible">
+<INFORMATION><title>Kinh Thánh</title></INFORMATION>
+<BIBLEBOOK bnumber="43" bname="Giăng">
+<CHAPTER cnumber="11">
+<CAPTION vref="35">Chúa Giê-xu khóc</CAPTION>
+<VERS vnumber="35">Đức Chúa Jêsus <gr str="1145">khóc</gr>.<XREF mscope="42;19;41;41"/></VERS>
+<VERS vnumber="43">Ngài kêu lớn tiếng:<BR art="x-nl"/><STYLE css="color: #FF0000;">Hỡi La-xa-rơ, hãy ra!</STYLE><MEDIA type="image" src="tomb.jpg">Mộ</MEDIA></VERS>
+</CHAPTER>
+</BIBLEBOOK>
+<BIBLEBOOK bnumber="44" bname="Công Vụ" bsname="Act">
+<CHAPTER cnumber="1">
+<VERS vnumber="1">Hỡi Thê-ô-phi-lơ<NOTE type="x-studynote">Nghĩa là bạn của Đức Chúa Trời</NOTE></VERS>
+</CHAPTER>
+</BIBLEBOOK>
+</XMLBIBLE>`
+
+	reader := NewZefaniaReader(strings.NewReader(zefania))
+
+	john, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+
+	if john.Book.Code != "43" || john.Book.Name != "Giăng" || john.Book.BookOrder != 43 {
+		t.Errorf("Next() book = %+v, want 43 Giăng", john.Book)
+	}
+
+	expectedVerses := []string{"Đức Chúa Jêsus khóc.", "Ngài kêu lớn tiếng: Hỡi La-xa-rơ, hãy ra!"}
+
+	if len(john.Verses) != len(expectedVerses) {
+		t.Fatalf("Next() = %d verses, want %d", len(john.Verses), len(expectedVerses))
+	}
+
+	for i, verse := range john.Verses {
+		if verse.Text != expectedVerses[i] {
+			t.Errorf("Next() verse %d = %q, want %q", i, verse.Text, expectedVerses[i])
+		}
+	}
+
+	if len(john.Headings) != 1 || john.Headings[0].Text != "Chúa Giê-xu khóc" || john.Headings[0].VerseId != "43.11.35" {
+		t.Errorf("Next() headings = %+v, want Chúa Giê-xu khóc", john.Headings)
+	}
+
+	expectedMarks := []*biblev1.Mark{
+		{Kind: biblev1.MarkKind_MARK_KIND_REFERENCE, Content: "42;19;41;41", StartOffset: 20, EndOffset: 20, TargetId: "43.11.35"},
+		{Content: "Hỡi La-xa-rơ, hãy ra!", Kind: biblev1.MarkKind_MARK_KIND_WORDS_OF_JESUS, StartOffset: 20, EndOffset: 41, TargetId: "43.11.43"},
+	}
+
+	if len(john.Marks) != len(expectedMarks) {
+		t.Fatalf("Next() marks = %+v, want %+v", john.Marks, expectedMarks)
+	}
+
+	for i, mark := range john.Marks {
+		expected := expectedMarks[i]
+
+		if mark.Kind != expected.Kind || mark.Content != expected.Content || mark.StartOffset != expected.StartOffset || mark.EndOffset != expected.EndOffset || mark.TargetId != expected.TargetId {
+			t.Errorf("Next() mark %d = %+v, want %+v", i, mark, expected)
+		}
+	}
+
+	if len(john.Diagnostics) != 1 || john.Diagnostics[0].Marker != "MEDIA" {
+		t.Errorf("Next() diagnostics = %+v, want MEDIA", john.Diagnostics)
+	}
+
+	acts, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+
+	if acts.Book.Code != "Act" || len(acts.Marks) != 1 || acts.Marks[0].Kind != biblev1.MarkKind_MARK_KIND_FOOTNOTE || acts.Marks[0].TargetId != "Act.1.1" {
+		t.Errorf("Next() = %+v %+v, want Act with a footnote", acts.Book, acts.Marks)
+	}
+
+	if _, err := reader.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("Next() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestImportZefania_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected error
+	}{
+		{
+			name:     "missing book id",
+			input:    `<XMLBIBLE><BIBLEBOOK bname="Genesis"></BIBLEBOOK></XMLBIBLE>`,
+			expected: ErrZefaniaMissingBookId,
+		},
+		{
+			name:     "invalid xml",
+			input:    `<XMLBIBLE><BIBLEBOOK bnumber="1"><CHAPTER cnumber="1">`,
+			expected: ErrZefaniaSyntax,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ImportZefania(strings.NewReader(tt.input))
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("ImportZefania() error = %v, want %v", err, tt.expected)
+			}
+		})
+	}
+}